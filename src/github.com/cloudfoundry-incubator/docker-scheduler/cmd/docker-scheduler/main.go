@@ -0,0 +1,78 @@
+// docker-scheduler serves the Docker API used by the Service Fabrik broker
+// and places containers and volumes across the engines of the docker job.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/api"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/cluster"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/config"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/docker-scheduler/config/config.json", "path to the configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading configuration: %v", err)
+	}
+
+	var engineTLS *tls.Config
+	if cfg.EngineTLS != nil {
+		if engineTLS, err = cfg.EngineTLS.ClientConfig(); err != nil {
+			log.Fatalf("loading engine TLS configuration: %v", err)
+		}
+	}
+	engines := make([]*engine.Engine, 0, len(cfg.Engines))
+	for _, addr := range cfg.Engines {
+		engines = append(engines, engine.New(addr, engineTLS))
+	}
+	c := cluster.New(cfg, engines)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Know the existing containers and volumes before serving requests
+	c.Refresh(ctx)
+	go c.Run(ctx)
+
+	server := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: api.NewServer(c),
+	}
+	if cfg.TLS != nil {
+		if server.TLSConfig, err = cfg.TLS.ServerConfig(); err != nil {
+			log.Fatalf("loading TLS configuration: %v", err)
+		}
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		log.Printf("shutting down")
+		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 30*time.Second)
+		defer shutdownCancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving the Docker API on %s for %d engines", cfg.Listen, len(engines))
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("serving: %v", err)
+	}
+}
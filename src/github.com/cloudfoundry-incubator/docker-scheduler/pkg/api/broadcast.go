@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/cluster"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+// forwardedHeaders are passed on to the engines by the calls sent to all of
+// them. X-Registry-Auth carries the registry credentials of a pull.
+var forwardedHeaders = []string{"Content-Type", "X-Registry-Auth"}
+
+// result is the response of one engine to a call sent to all of them.
+type result struct {
+	engine *engine.Engine
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+func (r *result) ok() bool {
+	return r.err == nil && r.status == http.StatusOK
+}
+
+// broadcast sends the request to all healthy engines concurrently and
+// returns their responses in the order of the engines. It answers 503 and
+// returns nil if no engine is healthy.
+func (s *Server) broadcast(w http.ResponseWriter, r *http.Request) []*result {
+	var engines []*engine.Engine
+	for _, e := range s.cluster.Engines() {
+		if e.Healthy() {
+			engines = append(engines, e)
+		}
+	}
+	if len(engines) == 0 {
+		writeError(w, http.StatusServiceUnavailable, cluster.ErrNoEngine.Error())
+		return nil
+	}
+	header := http.Header{}
+	for _, key := range forwardedHeaders {
+		if values, ok := r.Header[http.CanonicalHeaderKey(key)]; ok {
+			header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+
+	results := make([]*result, len(engines))
+	var wg sync.WaitGroup
+	for i, e := range engines {
+		wg.Add(1)
+		go func(i int, e *engine.Engine) {
+			defer wg.Done()
+			res := &result{engine: e}
+			results[i] = res
+			resp, err := e.Do(r.Context(), r.Method, r.URL.RequestURI(), header, nil)
+			if err != nil {
+				log.Printf("engine %s: %s %s failed: %v", e.Addr, r.Method, r.URL.Path, err)
+				res.err = err
+				return
+			}
+			defer resp.Body.Close()
+			res.status = resp.StatusCode
+			res.header = resp.Header
+			res.body, res.err = ioutil.ReadAll(resp.Body)
+		}(i, e)
+	}
+	wg.Wait()
+	return results
+}
+
+// writeResult writes the response of one engine as is.
+func writeResult(w http.ResponseWriter, res *result) {
+	if res.err != nil {
+		writeError(w, http.StatusBadGateway, res.err.Error())
+		return
+	}
+	if contentType := res.header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// firstFailure returns the first response that is not a 404, so that a
+// broken engine is not hidden behind the engines not knowing the object.
+func firstFailure(results []*result) *result {
+	for _, res := range results {
+		if res.err != nil || res.status != http.StatusNotFound {
+			return res
+		}
+	}
+	return results[0]
+}
+
+// listContainers merges the container lists of all engines, newest first
+// like the Docker API. The query, e.g. filters and all, is passed on. Like
+// classic Swarm, the names are prefixed with the node and the Node is added.
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	containers := []map[string]interface{}{}
+	for _, res := range results {
+		if !res.ok() {
+			writeResult(w, res)
+			return
+		}
+		var list []map[string]interface{}
+		if err := json.Unmarshal(res.body, &list); err != nil {
+			writeError(w, http.StatusBadGateway, "engine "+res.engine.Addr+": "+err.Error())
+			return
+		}
+		for _, container := range list {
+			if names, ok := container["Names"].([]interface{}); ok {
+				for i, name := range names {
+					if name, ok := name.(string); ok {
+						names[i] = "/" + res.engine.Addr + name
+					}
+				}
+			}
+			container["Node"] = node(res.engine)
+		}
+		containers = append(containers, list...)
+	}
+	sort.SliceStable(containers, func(i, j int) bool {
+		created := func(c map[string]interface{}) float64 {
+			v, _ := c["Created"].(float64)
+			return v
+		}
+		return created(containers[i]) > created(containers[j])
+	})
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < len(containers) {
+		containers = containers[:limit]
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+// listVolumes merges the volume lists of all engines. The names are not
+// prefixed with the node, as the volumes are addressed by their plain name
+// everywhere else.
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	merged := struct {
+		Volumes  []map[string]interface{} `json:"Volumes"`
+		Warnings []string                 `json:"Warnings"`
+	}{Volumes: []map[string]interface{}{}}
+	for _, res := range results {
+		if !res.ok() {
+			writeResult(w, res)
+			return
+		}
+		var list struct {
+			Volumes  []map[string]interface{}
+			Warnings []string
+		}
+		if err := json.Unmarshal(res.body, &list); err != nil {
+			writeError(w, http.StatusBadGateway, "engine "+res.engine.Addr+": "+err.Error())
+			return
+		}
+		merged.Volumes = append(merged.Volumes, list.Volumes...)
+		merged.Warnings = append(merged.Warnings, list.Warnings...)
+	}
+	writeJSON(w, http.StatusOK, merged)
+}
+
+// listImages merges the image lists of all engines. An image pulled on
+// several engines is listed once.
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	images := []map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, res := range results {
+		if !res.ok() {
+			writeResult(w, res)
+			return
+		}
+		var list []map[string]interface{}
+		if err := json.Unmarshal(res.body, &list); err != nil {
+			writeError(w, http.StatusBadGateway, "engine "+res.engine.Addr+": "+err.Error())
+			return
+		}
+		for _, image := range list {
+			id, _ := image["Id"].(string)
+			if id != "" && seen[id] {
+				continue
+			}
+			seen[id] = true
+			images = append(images, image)
+		}
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+// inspectImage answers with the image of the first engine having it.
+func (s *Server) inspectImage(w http.ResponseWriter, r *http.Request, name string) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	for _, res := range results {
+		if res.ok() {
+			writeResult(w, res)
+			return
+		}
+	}
+	writeResult(w, firstFailure(results))
+}
+
+// pullImage pulls the image on all engines, so that a container can be
+// placed on any of them. The progress output of the engines is
+// concatenated. It fails only if no engine could pull the image.
+func (s *Server) pullImage(w http.ResponseWriter, r *http.Request) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	var pulled []*result
+	for _, res := range results {
+		if res.ok() {
+			pulled = append(pulled, res)
+		} else {
+			log.Printf("engine %s: pulling %s failed", res.engine.Addr, r.URL.Query().Get("fromImage"))
+		}
+	}
+	if len(pulled) == 0 {
+		writeResult(w, firstFailure(results))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	for _, res := range pulled {
+		w.Write(res.body)
+	}
+}
+
+// removeImage removes the image from all engines. It succeeds if any engine
+// removed it and answers with the merged list of deleted and untagged
+// layers.
+func (s *Server) removeImage(w http.ResponseWriter, r *http.Request, name string) {
+	results := s.broadcast(w, r)
+	if results == nil {
+		return
+	}
+	removed := []interface{}{}
+	found := false
+	for _, res := range results {
+		if !res.ok() {
+			continue
+		}
+		found = true
+		var list []interface{}
+		if err := json.Unmarshal(res.body, &list); err == nil {
+			removed = append(removed, list...)
+		}
+	}
+	if !found {
+		writeResult(w, firstFailure(results))
+		return
+	}
+	log.Printf("removed image %s", name)
+	writeJSON(w, http.StatusOK, removed)
+}
@@ -0,0 +1,332 @@
+// Package api serves the subset of the Docker remote API used by the
+// broker's docker-manager and forwards each call to the engine owning the
+// container or volume.
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/cluster"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// Server is the http.Handler of the Docker API.
+type Server struct {
+	cluster *cluster.Cluster
+}
+
+// NewServer returns the Docker API for the given cluster.
+func NewServer(c *cluster.Cluster) *Server {
+	return &Server{cluster: c}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && path == "/_ping":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodGet && path == "/version":
+		s.version(w, r)
+	case r.Method == http.MethodGet && path == "/info":
+		s.info(w)
+	case r.Method == http.MethodGet && path == "/containers/json":
+		s.listContainers(w, r)
+	case r.Method == http.MethodPost && path == "/containers/create":
+		s.createContainer(w, r)
+	case len(parts) == 3 && parts[0] == "containers" && r.Method == http.MethodGet && parts[2] == "json":
+		s.inspectContainer(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "containers" && r.Method == http.MethodPost &&
+		(parts[2] == "start" || parts[2] == "stop" || parts[2] == "restart" || parts[2] == "kill"):
+		s.forwardContainer(w, r, parts[1], nil)
+	case len(parts) == 2 && parts[0] == "containers" && r.Method == http.MethodDelete:
+		s.removeContainer(w, r, parts[1])
+	case r.Method == http.MethodPost && path == "/volumes/create":
+		s.createVolume(w, r)
+	case r.Method == http.MethodGet && path == "/volumes":
+		s.listVolumes(w, r)
+	case len(parts) == 2 && parts[0] == "volumes" && r.Method == http.MethodGet:
+		s.forwardVolume(w, r, parts[1], nil)
+	case len(parts) == 2 && parts[0] == "volumes" && r.Method == http.MethodDelete:
+		s.forwardVolume(w, r, parts[1], func(resp *http.Response) error {
+			if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+				s.cluster.RemoveVolume(parts[1])
+			}
+			return nil
+		})
+	case r.Method == http.MethodGet && path == "/images/json":
+		s.listImages(w, r)
+	case r.Method == http.MethodPost && path == "/images/create":
+		s.pullImage(w, r)
+	// Image names contain slashes, e.g. registry:5000/org/redis:5
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		s.inspectImage(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json"))
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/images/"):
+		s.removeImage(w, r, strings.TrimPrefix(path, "/images/"))
+	default:
+		writeError(w, http.StatusNotFound, r.Method+" "+path+" is not supported by docker-scheduler")
+	}
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	for _, e := range s.cluster.Engines() {
+		if e.Healthy() {
+			s.forward(w, r, e, nil, nil)
+			return
+		}
+	}
+	writeError(w, http.StatusServiceUnavailable, cluster.ErrNoEngine.Error())
+}
+
+func (s *Server) info(w http.ResponseWriter) {
+	containers, volumes := s.cluster.Counts()
+	total := 0
+	status := [][2]string{{"Nodes", strconv.Itoa(len(s.cluster.Engines()))}}
+	for _, e := range s.cluster.Engines() {
+		total += containers[e]
+		status = append(status,
+			[2]string{e.Addr, ""},
+			[2]string{"  └ Status", e.Status()},
+			[2]string{"  └ Containers", strconv.Itoa(containers[e])},
+			[2]string{"  └ Volumes", strconv.Itoa(volumes[e])},
+		)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ID":           "docker-scheduler",
+		"Containers":   total,
+		"Driver":       "docker-scheduler",
+		"SystemStatus": status,
+	})
+}
+
+// createContainer places the container next to its named volumes, or on
+// the engine picked by the placement strategy.
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var spec struct {
+		HostConfig struct {
+			Binds  []string
+			Mounts []struct {
+				Type   string
+				Source string
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var volumes []string
+	for _, bind := range spec.HostConfig.Binds {
+		if source := strings.SplitN(bind, ":", 2)[0]; !strings.HasPrefix(source, "/") {
+			volumes = append(volumes, source)
+		}
+	}
+	for _, mount := range spec.HostConfig.Mounts {
+		if mount.Type == "volume" && mount.Source != "" {
+			volumes = append(volumes, mount.Source)
+		}
+	}
+
+	reservation, err := s.cluster.Place(volumes)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer reservation.Release()
+	e := reservation.Engine
+	name := r.URL.Query().Get("name")
+	s.forward(w, r, e, body, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusCreated {
+			return nil
+		}
+		var created struct {
+			ID string `json:"Id"`
+		}
+		if err := peekJSON(resp, &created); err != nil {
+			return err
+		}
+		log.Printf("created container %s (%s) on engine %s", created.ID, name, e.Addr)
+		s.cluster.AddContainer(e, created.ID, name)
+		// Docker created the named volumes the engine did not have yet
+		for _, volume := range volumes {
+			if _, ok := s.cluster.Volume(volume); !ok {
+				s.cluster.AddVolume(e, volume)
+			}
+		}
+		reservation.Release()
+		return nil
+	})
+}
+
+// inspectContainer adds the Node of the container to the inspect response,
+// like classic Swarm did.
+func (s *Server) inspectContainer(w http.ResponseWriter, r *http.Request, ref string) {
+	s.forwardContainer(w, r, ref, func(e *engine.Engine, id string, resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		var container map[string]interface{}
+		if err := peekJSON(resp, &container); err != nil {
+			return err
+		}
+		container["Node"] = node(e)
+		return replaceJSON(resp, container)
+	})
+}
+
+// node describes the engine like a classic Swarm node. The engines have no
+// name of their own, so the address is used as ID and name.
+func node(e *engine.Engine) map[string]string {
+	return map[string]string{
+		"ID":   e.Addr,
+		"Name": e.Addr,
+		"Addr": e.Addr,
+		"IP":   e.IP(),
+	}
+}
+
+func (s *Server) removeContainer(w http.ResponseWriter, r *http.Request, ref string) {
+	s.forwardContainer(w, r, ref, func(e *engine.Engine, id string, resp *http.Response) error {
+		if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+			log.Printf("removed container %s from engine %s", id, e.Addr)
+			s.cluster.RemoveContainer(id)
+		}
+		return nil
+	})
+}
+
+func (s *Server) forwardContainer(w http.ResponseWriter, r *http.Request, ref string, modify func(*engine.Engine, string, *http.Response) error) {
+	e, id, ok := s.cluster.Container(ref)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+ref)
+		return
+	}
+	var m func(*http.Response) error
+	if modify != nil {
+		m = func(resp *http.Response) error { return modify(e, id, resp) }
+	}
+	s.forward(w, r, e, nil, m)
+}
+
+// createVolume creates the volume on the engine already holding a volume
+// of that name, or on the engine picked by the placement strategy.
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var spec struct {
+		Name string
+	}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var volumes []string
+	if spec.Name != "" {
+		volumes = append(volumes, spec.Name)
+	}
+	reservation, err := s.cluster.Place(volumes)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer reservation.Release()
+	e := reservation.Engine
+	s.forward(w, r, e, body, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusCreated {
+			return nil
+		}
+		var created struct {
+			Name string
+		}
+		if err := peekJSON(resp, &created); err != nil {
+			return err
+		}
+		log.Printf("created volume %s on engine %s", created.Name, e.Addr)
+		s.cluster.AddVolume(e, created.Name)
+		reservation.Release()
+		return nil
+	})
+}
+
+func (s *Server) forwardVolume(w http.ResponseWriter, r *http.Request, name string, modify func(*http.Response) error) {
+	e, ok := s.cluster.Volume(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "get "+name+": no such volume")
+		return
+	}
+	s.forward(w, r, e, nil, modify)
+}
+
+// forward proxies the request to e, replacing its body if body is not nil.
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, e *engine.Engine, body []byte, modify func(*http.Response) error) {
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = e.URL.Scheme
+			req.URL.Host = e.URL.Host
+			req.Host = e.URL.Host
+			if body != nil {
+				req.Body = ioutil.NopCloser(bytes.NewReader(body))
+				req.ContentLength = int64(len(body))
+			}
+		},
+		Transport:      e.Transport,
+		ModifyResponse: modify,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("engine %s: %s %s failed: %v", e.Addr, r.Method, r.URL.Path, err)
+			writeError(w, http.StatusBadGateway, err.Error())
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// peekJSON decodes the response body into v and leaves the body readable.
+func peekJSON(resp *http.Response, v interface{}) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return json.Unmarshal(data, v)
+}
+
+func replaceJSON(resp *http.Response, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"message": message})
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/cluster"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/config"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+// fakeEngine serves the part of the Docker API the scheduler calls.
+type fakeEngine struct {
+	name string
+
+	mu         sync.Mutex
+	containers []map[string]interface{}
+	volumes    []string
+	images     map[string]bool
+	pulls      []string
+	auth       string
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+	case path == "/volumes":
+		list := []map[string]string{}
+		for _, name := range f.volumes {
+			list = append(list, map[string]string{"Name": name, "Driver": "lvm-volume-driver"})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Volumes": list})
+	case path == "/containers/json":
+		writeJSON(w, http.StatusOK, f.containers)
+	case path == "/containers/create":
+		id := fmt.Sprintf("%s-%d", f.name, len(f.containers))
+		f.containers = append(f.containers, map[string]interface{}{"Id": id, "Created": float64(len(f.containers))})
+		writeJSON(w, http.StatusCreated, map[string]string{"Id": id})
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Id": strings.Split(path, "/")[2]})
+	case path == "/images/json":
+		var list []map[string]string
+		for name := range f.images {
+			list = append(list, map[string]string{"Id": "sha256:" + name})
+		}
+		writeJSON(w, http.StatusOK, list)
+	case path == "/images/create":
+		name := r.URL.Query().Get("fromImage")
+		f.pulls = append(f.pulls, name)
+		f.auth = r.Header.Get("X-Registry-Auth")
+		f.images[name] = true
+		w.Write([]byte(`{"status":"pulled ` + name + ` on ` + f.name + `"}` + "\n"))
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		if !f.images[name] {
+			writeError(w, http.StatusNotFound, "No such image: "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"Id": "sha256:" + name, "Engine": f.name})
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/images/"):
+		name := strings.TrimPrefix(path, "/images/")
+		if !f.images[name] {
+			writeError(w, http.StatusNotFound, "No such image: "+name)
+			return
+		}
+		delete(f.images, name)
+		writeJSON(w, http.StatusOK, []map[string]string{{"Untagged": name}})
+	default:
+		writeError(w, http.StatusNotFound, "unexpected "+r.Method+" "+path)
+	}
+}
+
+// newTestServer returns the scheduler API over the given fake engines.
+func newTestServer(t *testing.T, fakes ...*fakeEngine) (*Server, func()) {
+	var (
+		engines []*engine.Engine
+		servers []*httptest.Server
+	)
+	for _, f := range fakes {
+		if f.images == nil {
+			f.images = map[string]bool{}
+		}
+		server := httptest.NewServer(f)
+		servers = append(servers, server)
+		engines = append(engines, engine.New(strings.TrimPrefix(server.URL, "http://"), nil))
+	}
+	c := cluster.New(&config.Config{Strategy: config.StrategySpread, Heartbeat: config.Duration(time.Second)}, engines)
+	c.Refresh(context.Background())
+	return NewServer(c), func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
+
+func do(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestListContainersMergesEngines(t *testing.T) {
+	a := &fakeEngine{name: "a", containers: []map[string]interface{}{
+		{"Id": "a1", "Created": 10.0, "Names": []string{"/redis1"}},
+		{"Id": "a2", "Created": 30.0, "Names": []string{"/redis2"}},
+	}}
+	b := &fakeEngine{name: "b", containers: []map[string]interface{}{{"Id": "b1", "Created": 20.0, "Names": []string{"/redis3"}}}}
+	s, done := newTestServer(t, a, b)
+	defer done()
+	engines := s.cluster.Engines()
+
+	rec := do(s, http.MethodGet, "/v1.24/containers/json?all=1&limit=2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var list []struct {
+		Id    string
+		Names []string
+		Node  struct{ Name, Addr string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Id != "a2" || list[1].Id != "b1" {
+		t.Fatalf("got %+v, want the two newest containers a2, b1", list)
+	}
+	for i, want := range []struct{ name, node string }{
+		{"/" + engines[0].Addr + "/redis2", engines[0].Addr},
+		{"/" + engines[1].Addr + "/redis3", engines[1].Addr},
+	} {
+		if len(list[i].Names) != 1 || list[i].Names[0] != want.name || list[i].Node.Addr != want.node {
+			t.Errorf("container %s: names %v on node %+v, want %s on %s", list[i].Id, list[i].Names, list[i].Node, want.name, want.node)
+		}
+	}
+}
+
+func TestListVolumesMergesEngines(t *testing.T) {
+	a := &fakeEngine{name: "a", volumes: []string{"v1", "v2"}}
+	b := &fakeEngine{name: "b", volumes: []string{"v3"}}
+	s, done := newTestServer(t, a, b)
+	defer done()
+
+	rec := do(s, http.MethodGet, "/v1.24/volumes", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var list struct{ Volumes []struct{ Name string } }
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range list.Volumes {
+		names = append(names, v.Name)
+	}
+	if strings.Join(names, ",") != "v1,v2,v3" {
+		t.Fatalf("got volumes %v, want v1,v2,v3", names)
+	}
+}
+
+func TestCreateContainerRecordsPlacement(t *testing.T) {
+	a, b := &fakeEngine{name: "a"}, &fakeEngine{name: "b"}
+	s, done := newTestServer(t, a, b)
+	defer done()
+
+	rec := do(s, http.MethodPost, "/containers/create?name=redis", `{"HostConfig":{"Binds":["data:/data"]}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	rec = do(s, http.MethodGet, "/containers/redis/json", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("inspect by name: status %d: %s", rec.Code, rec.Body)
+	}
+	var container struct {
+		Node struct{ Addr string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &container); err != nil {
+		t.Fatal(err)
+	}
+	if container.Node.Addr == "" {
+		t.Fatalf("no Node in %s", rec.Body)
+	}
+
+	// The volume Docker created with the container pins the next user
+	do(s, http.MethodPost, "/containers/create?name=backup", `{"HostConfig":{"Binds":["data:/backup:ro"]}}`)
+	if len(a.containers)+len(b.containers) != 2 || (len(a.containers) != 2 && len(b.containers) != 2) {
+		t.Fatalf("containers sharing volume data spread: a %d, b %d", len(a.containers), len(b.containers))
+	}
+}
+
+func TestPullImageOnAllEngines(t *testing.T) {
+	a, b := &fakeEngine{name: "a"}, &fakeEngine{name: "b"}
+	s, done := newTestServer(t, a, b)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/images/create?fromImage=registry:5000/org/redis&tag=5", nil)
+	req.Header.Set("X-Registry-Auth", "secret")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	for _, f := range []*fakeEngine{a, b} {
+		if len(f.pulls) != 1 || f.auth != "secret" {
+			t.Errorf("engine %s: pulls %v with auth %q", f.name, f.pulls, f.auth)
+		}
+		if !strings.Contains(rec.Body.String(), "on "+f.name) {
+			t.Errorf("output of engine %s missing: %s", f.name, rec.Body)
+		}
+	}
+
+	rec = do(s, http.MethodGet, "/images/json", "")
+	var list []struct{ Id string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("image pulled on both engines listed %d times", len(list))
+	}
+}
+
+func TestInspectAndRemoveImage(t *testing.T) {
+	a := &fakeEngine{name: "a"}
+	b := &fakeEngine{name: "b", images: map[string]bool{"org/redis:5": true}}
+	s, done := newTestServer(t, a, b)
+	defer done()
+
+	rec := do(s, http.MethodGet, "/images/org/redis:5/json", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Engine":"b"`) {
+		t.Fatalf("inspect: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(s, http.MethodGet, "/images/org/postgres/json", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("inspect of a missing image: status %d", rec.Code)
+	}
+
+	rec = do(s, http.MethodDelete, "/images/org/redis:5", "")
+	if rec.Code != http.StatusOK || b.images["org/redis:5"] {
+		t.Fatalf("remove: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(s, http.MethodDelete, "/images/org/redis:5", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("remove of a missing image: status %d", rec.Code)
+	}
+}
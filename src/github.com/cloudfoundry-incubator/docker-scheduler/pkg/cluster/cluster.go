@@ -0,0 +1,362 @@
+// Package cluster keeps track of the Docker engines, the containers and
+// volumes on them and places new containers and volumes.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/config"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+// ErrNoEngine is returned if no healthy engine can take a new container or
+// volume.
+var ErrNoEngine = errors.New("no healthy docker engine available")
+
+// Cluster is the set of Docker engines the scheduler places containers on.
+type Cluster struct {
+	engines        []*engine.Engine
+	strategy       string
+	maxContainers  int
+	heartbeat      time.Duration
+	failureRetries int
+
+	mu         sync.RWMutex
+	containers map[string]*engine.Engine // by container ID
+	names      map[string]string         // container name to ID
+	volumes    map[string]*engine.Engine // by volume name
+	// Containers and volumes created or removed through the scheduler, so a
+	// reload listing taken before the change does not revert it
+	containerChanges map[string]change
+	volumeChanges    map[string]change
+	// Placements whose create call has not returned yet
+	reserved       map[*engine.Engine]int
+	pendingVolumes map[string]*engine.Engine
+}
+
+type change struct {
+	engine *engine.Engine
+	at     time.Time
+}
+
+// New returns the cluster of the given engines.
+func New(cfg *config.Config, engines []*engine.Engine) *Cluster {
+	return &Cluster{
+		engines:          engines,
+		strategy:         cfg.Strategy,
+		maxContainers:    cfg.MaxContainers,
+		heartbeat:        time.Duration(cfg.Heartbeat),
+		failureRetries:   cfg.FailureRetries,
+		containers:       map[string]*engine.Engine{},
+		names:            map[string]string{},
+		volumes:          map[string]*engine.Engine{},
+		containerChanges: map[string]change{},
+		volumeChanges:    map[string]change{},
+		reserved:         map[*engine.Engine]int{},
+		pendingVolumes:   map[string]*engine.Engine{},
+	}
+}
+
+// Engines returns the engines of the cluster.
+func (c *Cluster) Engines() []*engine.Engine {
+	return c.engines
+}
+
+// Run checks the health of the engines and refreshes their containers and
+// volumes every heartbeat until ctx is done.
+func (c *Cluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+	for {
+		c.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh checks the health of all engines and reloads the containers and
+// volumes of the healthy ones.
+func (c *Cluster) Refresh(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range c.engines {
+		wg.Add(1)
+		go func(e *engine.Engine) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.heartbeat)
+			defer cancel()
+			if err := e.Heartbeat(ctx, c.failureRetries); err != nil {
+				log.Printf("engine %s: heartbeat failed: %v", e.Addr, err)
+				return
+			}
+			if err := c.reload(ctx, e); err != nil {
+				log.Printf("engine %s: reloading containers and volumes failed: %v", e.Addr, err)
+			}
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (c *Cluster) reload(ctx context.Context, e *engine.Engine) error {
+	listedAt := time.Now()
+	containers, err := e.Containers(ctx)
+	if err != nil {
+		return err
+	}
+	volumes, err := e.Volumes(ctx)
+	if err != nil {
+		return err
+	}
+	c.apply(e, listedAt, containers, volumes)
+	return nil
+}
+
+// apply merges the listing of e taken at listedAt. Containers and volumes
+// created or removed through the scheduler after listedAt keep their state,
+// everything else of e is replaced by the listing.
+func (c *Cluster) apply(e *engine.Engine, listedAt time.Time, containers []engine.Container, volumes []engine.Volume) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, owner := range c.containers {
+		if owner == e && !changedSince(c.containerChanges, id, listedAt) {
+			c.forgetContainerLocked(id)
+		}
+	}
+	for name, owner := range c.volumes {
+		if owner == e && !changedSince(c.volumeChanges, name, listedAt) {
+			delete(c.volumes, name)
+		}
+	}
+	for _, container := range containers {
+		if changedSince(c.containerChanges, container.ID, listedAt) {
+			continue
+		}
+		c.containers[container.ID] = e
+		for _, name := range container.Names {
+			c.names[strings.TrimPrefix(name, "/")] = container.ID
+		}
+	}
+	for _, volume := range volumes {
+		if !changedSince(c.volumeChanges, volume.Name, listedAt) {
+			c.volumes[volume.Name] = e
+		}
+	}
+	// Changes on e before listedAt are part of the listing now
+	prune(c.containerChanges, e, listedAt)
+	prune(c.volumeChanges, e, listedAt)
+}
+
+func changedSince(changes map[string]change, key string, t time.Time) bool {
+	ch, ok := changes[key]
+	return ok && ch.at.After(t)
+}
+
+func prune(changes map[string]change, e *engine.Engine, t time.Time) {
+	for key, ch := range changes {
+		if ch.engine == e && !ch.at.After(t) {
+			delete(changes, key)
+		}
+	}
+}
+
+// Reservation is a placement on Engine whose create call is in flight. It
+// counts against the container limit of the engine until it is released.
+type Reservation struct {
+	Engine *engine.Engine
+
+	cluster  *Cluster
+	volumes  []string
+	released bool
+}
+
+// Release ends the reservation. Call it once the create call returned,
+// after recording the created container or volume.
+func (r *Reservation) Release() {
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.released {
+		return
+	}
+	r.released = true
+	c.reserved[r.Engine]--
+	if c.reserved[r.Engine] == 0 {
+		delete(c.reserved, r.Engine)
+	}
+	for _, name := range r.volumes {
+		if c.pendingVolumes[name] == r.Engine {
+			delete(c.pendingVolumes, name)
+		}
+	}
+}
+
+// Place picks the engine for a new container or volume and reserves a slot
+// on it. If volumes are given, the engine already holding them (or about to
+// create them) is used so that a container ends up next to its data.
+func (c *Cluster) Place(volumes []string) (*Reservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.pickLocked(volumes)
+	if err != nil {
+		return nil, err
+	}
+	c.reserved[e]++
+	r := &Reservation{Engine: e, cluster: c}
+	for _, name := range volumes {
+		if _, ok := c.volumes[name]; !ok {
+			if _, ok := c.pendingVolumes[name]; !ok {
+				c.pendingVolumes[name] = e
+				r.volumes = append(r.volumes, name)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (c *Cluster) pickLocked(volumes []string) (*engine.Engine, error) {
+	for _, name := range volumes {
+		e, ok := c.volumes[name]
+		if !ok {
+			e, ok = c.pendingVolumes[name]
+		}
+		if ok {
+			if !e.Healthy() {
+				return nil, ErrNoEngine
+			}
+			return e, nil
+		}
+	}
+
+	counts := map[*engine.Engine]int{}
+	for _, e := range c.containers {
+		counts[e]++
+	}
+	for e, n := range c.reserved {
+		counts[e] += n
+	}
+	var best *engine.Engine
+	for _, e := range c.engines {
+		if !e.Healthy() || (c.maxContainers > 0 && counts[e] >= c.maxContainers) {
+			continue
+		}
+		if best == nil || c.better(counts[e], counts[best]) {
+			best = e
+		}
+	}
+	if best == nil {
+		return nil, ErrNoEngine
+	}
+	return best, nil
+}
+
+func (c *Cluster) better(count, bestCount int) bool {
+	if c.strategy == config.StrategyBinpack {
+		return count > bestCount
+	}
+	return count < bestCount
+}
+
+// Container returns the engine of the container with the given ID, unique
+// ID prefix or name.
+func (c *Cluster) Container(ref string) (*engine.Engine, string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if e, ok := c.containers[ref]; ok {
+		return e, ref, true
+	}
+	if id, ok := c.names[strings.TrimPrefix(ref, "/")]; ok {
+		return c.containers[id], id, true
+	}
+	var (
+		match string
+		found *engine.Engine
+	)
+	for id, e := range c.containers {
+		if strings.HasPrefix(id, ref) {
+			if found != nil {
+				return nil, "", false
+			}
+			match, found = id, e
+		}
+	}
+	return found, match, found != nil
+}
+
+// Volume returns the engine of the named volume.
+func (c *Cluster) Volume(name string) (*engine.Engine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.volumes[name]
+	return e, ok
+}
+
+// AddContainer records a container created on e.
+func (c *Cluster) AddContainer(e *engine.Engine, id, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.containers[id] = e
+	c.containerChanges[id] = change{e, time.Now()}
+	if name != "" {
+		c.names[strings.TrimPrefix(name, "/")] = id
+	}
+}
+
+// RemoveContainer forgets a removed container.
+func (c *Cluster) RemoveContainer(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.containers[id]; ok {
+		c.containerChanges[id] = change{e, time.Now()}
+	}
+	c.forgetContainerLocked(id)
+}
+
+func (c *Cluster) forgetContainerLocked(id string) {
+	delete(c.containers, id)
+	for name, nameID := range c.names {
+		if nameID == id {
+			delete(c.names, name)
+		}
+	}
+}
+
+// AddVolume records a volume created on e.
+func (c *Cluster) AddVolume(e *engine.Engine, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.volumes[name] = e
+	c.volumeChanges[name] = change{e, time.Now()}
+}
+
+// RemoveVolume forgets a removed volume.
+func (c *Cluster) RemoveVolume(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.volumes[name]; ok {
+		c.volumeChanges[name] = change{e, time.Now()}
+	}
+	delete(c.volumes, name)
+}
+
+// Counts returns the number of containers and volumes per engine.
+func (c *Cluster) Counts() (containers, volumes map[*engine.Engine]int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	containers = map[*engine.Engine]int{}
+	volumes = map[*engine.Engine]int{}
+	for _, e := range c.containers {
+		containers[e]++
+	}
+	for _, e := range c.volumes {
+		volumes[e]++
+	}
+	return containers, volumes
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/config"
+	"github.com/cloudfoundry-incubator/docker-scheduler/pkg/engine"
+)
+
+// healthyEngines returns n engines answering /_ping, already marked
+// healthy.
+func healthyEngines(t *testing.T, n int) ([]*engine.Engine, func()) {
+	var (
+		engines []*engine.Engine
+		servers []*httptest.Server
+	)
+	for i := 0; i < n; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+		}))
+		servers = append(servers, server)
+		e := engine.New(strings.TrimPrefix(server.URL, "http://"), nil)
+		if err := e.Heartbeat(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+		engines = append(engines, e)
+	}
+	return engines, func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
+
+func newCluster(engines []*engine.Engine, strategy string, maxContainers int) *Cluster {
+	return New(&config.Config{Strategy: strategy, MaxContainers: maxContainers, Heartbeat: config.Duration(time.Second)}, engines)
+}
+
+func TestPlaceReservesSlot(t *testing.T) {
+	engines, done := healthyEngines(t, 1)
+	defer done()
+	c := newCluster(engines, config.StrategySpread, 1)
+
+	r, err := c.Place(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Place(nil); err != ErrNoEngine {
+		t.Fatalf("second placement while the first is in flight: got %v, want %v", err, ErrNoEngine)
+	}
+	r.Release()
+	r.Release()
+	if _, err := c.Place(nil); err != nil {
+		t.Fatalf("placement after release: %v", err)
+	}
+}
+
+func TestPlaceSpreadsConcurrentCreates(t *testing.T) {
+	engines, done := healthyEngines(t, 2)
+	defer done()
+	c := newCluster(engines, config.StrategySpread, 0)
+
+	first, err := c.Place(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Place(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Engine == second.Engine {
+		t.Fatalf("both in-flight creates placed on %s", first.Engine.Addr)
+	}
+
+	// Once created, the container counts instead of the reservation
+	c.AddContainer(first.Engine, "a", "")
+	first.Release()
+	second.Release()
+	third, err := c.Place(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Engine == first.Engine {
+		t.Fatalf("placed on %s with a container and an idle engine", first.Engine.Addr)
+	}
+}
+
+func TestPlaceKeepsPendingVolumeTogether(t *testing.T) {
+	engines, done := healthyEngines(t, 2)
+	defer done()
+	c := newCluster(engines, config.StrategySpread, 0)
+
+	first, err := c.Place([]string{"data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Place([]string{"data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Engine != second.Engine {
+		t.Fatalf("volume data placed on %s and %s", first.Engine.Addr, second.Engine.Addr)
+	}
+
+	first.Release()
+	second.Release()
+	if len(c.pendingVolumes) != 0 || len(c.reserved) != 0 {
+		t.Fatalf("reservations left after release: %v %v", c.pendingVolumes, c.reserved)
+	}
+}
+
+func TestApplyKeepsChangesNewerThanListing(t *testing.T) {
+	engines, done := healthyEngines(t, 1)
+	defer done()
+	e := engines[0]
+	c := newCluster(engines, config.StrategySpread, 0)
+	c.AddContainer(e, "old", "old")
+	c.AddVolume(e, "gone")
+
+	listedAt := time.Now()
+	time.Sleep(time.Millisecond)
+	// Created and removed while the listing was in flight
+	c.AddContainer(e, "new", "new")
+	c.AddVolume(e, "data")
+	c.RemoveContainer("old")
+
+	c.apply(e, listedAt,
+		[]engine.Container{{ID: "old", Names: []string{"/old"}}, {ID: "other", Names: []string{"/other"}}},
+		nil)
+
+	if _, _, ok := c.Container("new"); !ok {
+		t.Error("container created after the listing was dropped")
+	}
+	if _, _, ok := c.Container("old"); ok {
+		t.Error("container removed after the listing came back")
+	}
+	if _, _, ok := c.Container("other"); !ok {
+		t.Error("listed container missing")
+	}
+	if _, ok := c.Volume("data"); !ok {
+		t.Error("volume created after the listing was dropped")
+	}
+	if _, ok := c.Volume("gone"); ok {
+		t.Error("volume missing from the listing was kept")
+	}
+
+	// A later listing is authoritative again
+	c.apply(e, time.Now(), nil, nil)
+	if _, _, ok := c.Container("new"); ok {
+		t.Error("container missing from a newer listing was kept")
+	}
+	if len(c.containerChanges) != 0 || len(c.volumeChanges) != 0 {
+		t.Errorf("changes left after a newer listing: %v %v", c.containerChanges, c.volumeChanges)
+	}
+}
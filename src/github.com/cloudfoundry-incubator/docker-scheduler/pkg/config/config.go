@@ -0,0 +1,153 @@
+// Package config loads the docker-scheduler configuration rendered by the
+// docker-scheduler BOSH job.
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Strategies supported for placing new containers and volumes.
+const (
+	StrategySpread  = "spread"
+	StrategyBinpack = "binpack"
+)
+
+// Config is the docker-scheduler configuration.
+type Config struct {
+	// Listen is the host:port the Docker API is served on.
+	Listen string `json:"listen"`
+	// TLS secures the Docker API served to the broker.
+	TLS *TLS `json:"tls,omitempty"`
+	// Engines are the host:port addresses of the Docker engines.
+	Engines []string `json:"engines"`
+	// EngineTLS is used to connect to the Docker engines.
+	EngineTLS *TLS `json:"engine_tls,omitempty"`
+	// Heartbeat is the interval of the engine health checks.
+	Heartbeat Duration `json:"heartbeat"`
+	// FailureRetries is the number of failed health checks after which an
+	// engine is no longer considered for placement.
+	FailureRetries int `json:"failure_retries"`
+	// Strategy is the placement strategy [spread, binpack].
+	Strategy string `json:"strategy"`
+	// MaxContainers limits the containers per engine, 0 means unlimited.
+	MaxContainers int `json:"max_containers"`
+}
+
+// TLS holds the paths to the certificates of a TLS connection.
+type TLS struct {
+	CA   string `json:"ca,omitempty"`
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Verify requires client certificates on the served API.
+	Verify bool `json:"verify"`
+	// SkipVerify turns off the verification of the engine certificates,
+	// which are verified against CA otherwise.
+	SkipVerify bool `json:"skip_verify,omitempty"`
+}
+
+// Duration is a time.Duration read from a string such as "20s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Listen:         ":2376",
+		Heartbeat:      Duration(20 * time.Second),
+		FailureRetries: 3,
+		Strategy:       StrategySpread,
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if len(cfg.Engines) == 0 {
+		return nil, fmt.Errorf("no docker engines configured")
+	}
+	if cfg.Strategy != StrategySpread && cfg.Strategy != StrategyBinpack {
+		return nil, fmt.Errorf("unsupported strategy %q", cfg.Strategy)
+	}
+	if cfg.Heartbeat <= 0 {
+		return nil, fmt.Errorf("heartbeat must be positive")
+	}
+	return cfg, nil
+}
+
+// ServerConfig builds the tls.Config of the served Docker API. Client
+// certificates are required if Verify is set.
+func (t *TLS) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.Verify {
+		pool, err := t.pool()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientConfig builds the tls.Config used to connect to the Docker engines.
+// The engine certificates are verified unless SkipVerify is set.
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.SkipVerify,
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if t.CA != "" {
+		pool, err := t.pool()
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func (t *TLS) pool() (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(t.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", t.CA)
+	}
+	return pool, nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestClientConfigVerifiesByDefault(t *testing.T) {
+	cfg, err := (&TLS{}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InsecureSkipVerify {
+		t.Error("engine certificates are not verified by default")
+	}
+
+	cfg, err = (&TLS{SkipVerify: true}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.InsecureSkipVerify {
+		t.Error("skip_verify is ignored")
+	}
+}
@@ -0,0 +1,182 @@
+// Package engine talks to a single Docker engine and tracks its health.
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Container is the part of a Docker container listing the scheduler needs.
+type Container struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+}
+
+// Volume is the part of a Docker volume listing the scheduler needs.
+type Volume struct {
+	Name string `json:"Name"`
+}
+
+// Engine is a Docker engine reachable over HTTP(S). Any server speaking the
+// Docker remote API, e.g. an httptest.Server, can act as engine.
+type Engine struct {
+	Addr      string
+	URL       *url.URL
+	Transport http.RoundTripper
+
+	client *http.Client
+	// stream has no timeout, image pulls take as long as they take
+	stream *http.Client
+
+	mu       sync.RWMutex
+	healthy  bool
+	failures int
+	lastErr  error
+}
+
+// New returns the engine at addr (host:port), connecting with tlsConfig if
+// it is not nil.
+func New(addr string, tlsConfig *tls.Config) *Engine {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &Engine{
+		Addr:      addr,
+		URL:       &url.URL{Scheme: scheme, Host: addr},
+		Transport: transport,
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		stream:    &http.Client{Transport: transport},
+	}
+}
+
+// Healthy reports whether the engine is considered for placement.
+func (e *Engine) Healthy() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.healthy
+}
+
+// Status returns a human readable health status of the engine.
+func (e *Engine) Status() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	switch {
+	case e.healthy:
+		return "Healthy"
+	case e.lastErr != nil:
+		return fmt.Sprintf("Unhealthy (%d failures): %v", e.failures, e.lastErr)
+	default:
+		return "Pending"
+	}
+}
+
+// Heartbeat pings the engine. The engine turns healthy on success and
+// unhealthy once more than retries consecutive pings failed.
+func (e *Engine) Heartbeat(ctx context.Context, retries int) error {
+	err := e.ping(ctx)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.failures++
+		e.lastErr = err
+		if e.failures > retries {
+			e.healthy = false
+		}
+		return err
+	}
+	e.failures = 0
+	e.lastErr = nil
+	e.healthy = true
+	return nil
+}
+
+func (e *Engine) ping(ctx context.Context) error {
+	resp, err := e.get(ctx, "/_ping")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Containers lists all containers of the engine, including stopped ones.
+func (e *Engine) Containers(ctx context.Context) ([]Container, error) {
+	var containers []Container
+	if err := e.getJSON(ctx, "/containers/json?all=1", &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// Volumes lists the volumes of the engine.
+func (e *Engine) Volumes(ctx context.Context) ([]Volume, error) {
+	var list struct {
+		Volumes []Volume `json:"Volumes"`
+	}
+	if err := e.getJSON(ctx, "/volumes", &list); err != nil {
+		return nil, err
+	}
+	return list.Volumes, nil
+}
+
+// Do sends a request to the engine. path includes the query string. The
+// caller has to close the response body.
+func (e *Engine) Do(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, e.URL.String()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return e.stream.Do(req.WithContext(ctx))
+}
+
+// IP returns the host part of the engine address.
+func (e *Engine) IP() string {
+	if i := strings.LastIndex(e.Addr, ":"); i >= 0 {
+		return e.Addr[:i]
+	}
+	return e.Addr
+}
+
+func (e *Engine) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := e.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (e *Engine) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, e.URL.String()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s on %s returned %s", path, e.Addr, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,72 @@
+// hook-launcher runs a deployment hook action such as ReserveIps.js or
+// Blueprint_PreCreate with a seccomp filter built from the syscall
+// whitelist, resource limits, a timeout and a read-only filesystem view.
+//
+// Usage: hook-launcher -config <file> -action <name> -- <command> [args...]
+//
+// The exit code of the action is passed through. If the sandbox stops the
+// action, a JSON error line {"error": {"code": ..., ...}} replaces the
+// output of the action, which the hook server returns to the broker, and
+// is logged to stderr.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/hook-launcher/pkg/sandbox"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/service-fabrik-deployment-hooks/config/launcher.json", "path to the configuration file")
+	action := flag.String("action", "", "name of the action, used in reported errors")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: hook-launcher -config <file> -action <name> -- <command> [args...]")
+		os.Exit(2)
+	}
+	if *action == "" {
+		*action = actionName(args)
+	}
+
+	cfg, err := sandbox.Load(*configPath)
+	if err != nil {
+		report(&sandbox.Error{Code: sandbox.CodeSetupFailed, Action: *action, Message: err.Error(), ExitCode: sandbox.ExitSetupFailed})
+		os.Exit(sandbox.ExitSetupFailed)
+	}
+
+	if sandbox.IsInit() {
+		err := sandbox.Init(cfg, *action, args)
+		report(err)
+		os.Exit(sandbox.ExitSetupFailed)
+	}
+
+	code, err := sandbox.Run(cfg, *action, args, os.Stdout)
+	if err != nil {
+		report(err)
+	}
+	os.Exit(code)
+}
+
+// actionName defaults the action to the script run by an interpreter
+// (node .../ReserveIps.js) or to the executable itself.
+func actionName(args []string) string {
+	for _, arg := range args[1:] {
+		if strings.Contains(arg, "/") && !strings.HasPrefix(arg, "-") {
+			return strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+		}
+	}
+	return filepath.Base(args[0])
+}
+
+func report(err error) {
+	if e, ok := err.(*sandbox.Error); ok {
+		e.Report(os.Stderr)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
@@ -0,0 +1,73 @@
+// Package sandbox runs a deployment hook action with a seccomp filter,
+// resource limits, a timeout and an optional read-only filesystem view.
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the launcher configuration rendered by the
+// service-fabrik-deployment-hooks job.
+type Config struct {
+	Seccomp       Seccomp  `json:"seccomp"`
+	Timeout       Duration `json:"timeout"`
+	Rlimits       Rlimits  `json:"rlimits"`
+	ReadOnlyRoot  bool     `json:"read_only_root"`
+	WritablePaths []string `json:"writable_paths"`
+	// MaxOutputMB caps the output held back until the action exits, 0
+	// means unlimited.
+	MaxOutputMB uint64 `json:"max_output_mb"`
+}
+
+// Seccomp configures the syscall filter.
+type Seccomp struct {
+	Enabled         bool     `json:"enabled"`
+	AllowedSyscalls []string `json:"allowed_syscalls"`
+	// DefaultAction is taken for syscalls not allowed [kill, errno, log].
+	DefaultAction string `json:"default_action"`
+}
+
+// Rlimits are the resource limits of an action, 0 leaves a limit as is.
+type Rlimits struct {
+	AddressSpaceMB uint64 `json:"address_space_mb"`
+	CPUSeconds     uint64 `json:"cpu_seconds"`
+	OpenFiles      uint64 `json:"open_files"`
+	Processes      uint64 `json:"processes"`
+	FileSizeMB     uint64 `json:"file_size_mb"`
+}
+
+// Duration is a time.Duration read from a string such as "300s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Seccomp:     Seccomp{DefaultAction: "kill"},
+		MaxOutputMB: 16,
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return cfg, nil
+}
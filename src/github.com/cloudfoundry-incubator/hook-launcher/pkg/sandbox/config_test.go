@@ -0,0 +1,32 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"seccomp": {"enabled": true, "allowed_syscalls": ["read"]}, "timeout": "300s"}`)
+	f.Close()
+
+	cfg, err := Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seccomp.DefaultAction != "kill" {
+		t.Errorf("default action %q, want kill", cfg.Seccomp.DefaultAction)
+	}
+	if time.Duration(cfg.Timeout) != 300*time.Second {
+		t.Errorf("timeout %s, want 300s", time.Duration(cfg.Timeout))
+	}
+	if cfg.MaxOutputMB != 16 {
+		t.Errorf("output limit %d MB, want 16", cfg.MaxOutputMB)
+	}
+}
@@ -0,0 +1,41 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Error codes reported for failed actions.
+const (
+	CodeSyscallViolation      = "SyscallViolation"
+	CodeTimeout               = "Timeout"
+	CodeResourceLimitExceeded = "ResourceLimitExceeded"
+	CodeSetupFailed           = "SetupFailed"
+)
+
+// Exit codes of the launcher besides the exit code of the action.
+const (
+	ExitSetupFailed         = 125
+	ExitTimeout             = 124
+	ExitOutputLimitExceeded = 123
+)
+
+// Error is the structured error reported for an action the sandbox
+// stopped, so that the hook server can tell it from a failing action.
+type Error struct {
+	Code     string `json:"code"`
+	Action   string `json:"action"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: action %s: %s", e.Code, e.Action, e.Message)
+}
+
+// Report writes the error as a single JSON line to w.
+func (e *Error) Report(w io.Writer) {
+	data, _ := json.Marshal(map[string]*Error{"error": e})
+	fmt.Fprintf(w, "%s\n", data)
+}
@@ -0,0 +1,319 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/cloudfoundry-incubator/hook-launcher/pkg/seccomp"
+)
+
+// stageEnv marks the re-executed launcher which sets up the sandbox and
+// execs the action.
+const stageEnv = "HOOK_LAUNCHER_STAGE"
+
+// IsInit reports whether the process is the re-executed launcher.
+func IsInit() bool {
+	return os.Getenv(stageEnv) == "init"
+}
+
+// Run starts the action in the sandbox, waits for it and returns its exit
+// code. Actions stopped by the sandbox are returned as *Error.
+//
+// The output of the action is the response the hook server passes on to
+// the broker. It is held back until the action exits: if the sandbox
+// stopped the action, the error replaces the output, so that the broker
+// gets the reason instead of a truncated response.
+func Run(cfg *Config, action string, args []string, stdout io.Writer) (int, error) {
+	output := &limitedBuffer{max: int(cfg.MaxOutputMB * 1024 * 1024)}
+	code, err := run(cfg, action, output)
+	if err == nil && output.truncated {
+		code = ExitOutputLimitExceeded
+		err = &Error{
+			Code:     CodeResourceLimitExceeded,
+			Action:   action,
+			Message:  fmt.Sprintf("action output exceeds %d MB", cfg.MaxOutputMB),
+			ExitCode: code,
+		}
+	}
+	if e, ok := err.(*Error); ok {
+		e.Report(stdout)
+		return code, err
+	}
+	stdout.Write(output.buf.Bytes())
+	return code, err
+}
+
+// limitedBuffer holds up to max bytes, 0 means unlimited. It drops the
+// rest of the output, so that the action does not block on a full pipe,
+// and records that it did. The buffer is not embedded, as io.Copy would
+// use its ReadFrom instead of Write.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max > 0 && b.buf.Len()+len(p) > b.max {
+		p = p[:b.max-b.buf.Len()]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func run(cfg *Config, action string, stdout io.Writer) (int, error) {
+	if cfg.Seccomp.Enabled {
+		if _, err := seccomp.New(cfg.Seccomp.AllowedSyscalls, cfg.Seccomp.DefaultAction); err != nil {
+			return ExitSetupFailed, &Error{Code: CodeSetupFailed, Action: action, Message: err.Error(), ExitCode: ExitSetupFailed}
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return ExitSetupFailed, &Error{Code: CodeSetupFailed, Action: action, Message: err.Error(), ExitCode: ExitSetupFailed}
+	}
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, os.Stderr
+	// The init stage must not be preempted between installing the filter
+	// and the exec of the action
+	cmd.Env = append(os.Environ(), stageEnv+"=init", "GODEBUG=asyncpreemptoff=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if cfg.ReadOnlyRoot {
+		uid, gid := os.Getuid(), os.Getgid()
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	}
+	if err := cmd.Start(); err != nil {
+		return ExitSetupFailed, &Error{Code: CodeSetupFailed, Action: action, Message: err.Error(), ExitCode: ExitSetupFailed}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var timeout <-chan time.Time
+	if cfg.Timeout > 0 {
+		timer := time.NewTimer(time.Duration(cfg.Timeout))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+	case <-timeout:
+		// Kill the whole process group, the action may have forked
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return ExitTimeout, &Error{
+			Code:     CodeTimeout,
+			Action:   action,
+			Message:  fmt.Sprintf("action did not complete within %s", time.Duration(cfg.Timeout)),
+			ExitCode: ExitTimeout,
+		}
+	}
+
+	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !status.Signaled() {
+		return status.ExitStatus(), nil
+	}
+	code := 128 + int(status.Signal())
+	switch status.Signal() {
+	case syscall.SIGSYS:
+		return code, &Error{
+			Code:     CodeSyscallViolation,
+			Action:   action,
+			Message:  "action was killed for calling a syscall which is not whitelisted, see the kernel audit log for the syscall number",
+			ExitCode: code,
+		}
+	case syscall.SIGXCPU, syscall.SIGXFSZ:
+		return code, &Error{
+			Code:     CodeResourceLimitExceeded,
+			Action:   action,
+			Message:  fmt.Sprintf("action was killed by %s", status.Signal()),
+			ExitCode: code,
+		}
+	}
+	return code, nil
+}
+
+// Init sets up the sandbox in the re-executed launcher and execs the action.
+// It only returns on failure.
+func Init(cfg *Config, action string, args []string) error {
+	runtime.LockOSThread()
+	setupFailed := func(err error) error {
+		return &Error{Code: CodeSetupFailed, Action: action, Message: err.Error(), ExitCode: ExitSetupFailed}
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return setupFailed(err)
+	}
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, stageEnv+"=") && !strings.HasPrefix(kv, "GODEBUG=") {
+			env = append(env, kv)
+		}
+	}
+	// Everything execve needs is built up front: once the filter is
+	// installed only the raw execve below runs, nothing that allocates or
+	// calls into the runtime
+	pathp, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return setupFailed(err)
+	}
+	argvp, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		return setupFailed(err)
+	}
+	envp, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return setupFailed(err)
+	}
+
+	if cfg.ReadOnlyRoot {
+		if err := readOnlyRoot(cfg.WritablePaths); err != nil {
+			return setupFailed(err)
+		}
+	}
+	if err := setRlimits(cfg.Rlimits); err != nil {
+		return setupFailed(err)
+	}
+	var filter *seccomp.Filter
+	if cfg.Seccomp.Enabled {
+		if filter, err = seccomp.New(cfg.Seccomp.AllowedSyscalls, cfg.Seccomp.DefaultAction); err != nil {
+			return setupFailed(err)
+		}
+		if err := filter.Install(); err != nil {
+			return setupFailed(err)
+		}
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE,
+		uintptr(unsafe.Pointer(pathp)),
+		uintptr(unsafe.Pointer(&argvp[0])),
+		uintptr(unsafe.Pointer(&envp[0])))
+	runtime.KeepAlive(pathp)
+	runtime.KeepAlive(argvp)
+	runtime.KeepAlive(envp)
+	return setupFailed(fmt.Errorf("executing %s: %v", path, errno))
+}
+
+func setRlimits(limits Rlimits) error {
+	const mb = 1024 * 1024
+	for _, limit := range []struct {
+		resource int
+		name     string
+		value    uint64
+	}{
+		{syscall.RLIMIT_AS, "address space", limits.AddressSpaceMB * mb},
+		{syscall.RLIMIT_CPU, "cpu time", limits.CPUSeconds},
+		{syscall.RLIMIT_NOFILE, "open files", limits.OpenFiles},
+		{6 /* RLIMIT_NPROC */, "processes", limits.Processes},
+		{syscall.RLIMIT_FSIZE, "file size", limits.FileSizeMB * mb},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			return fmt.Errorf("setting %s limit: %v", limit.name, err)
+		}
+	}
+	return nil
+}
+
+// readOnlyRoot remounts all mounts of the private mount namespace read-only
+// except for the writable paths, which are bind-mounted onto themselves
+// first so they get mounts of their own.
+func readOnlyRoot(writable []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %v", err)
+	}
+	keep := map[string]bool{}
+	for _, path := range writable {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind-mounting %s: %v", path, err)
+		}
+		keep[path] = true
+	}
+
+	mounts, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer mounts.Close()
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountpoint, options := unescapeMountinfo(fields[4]), fields[5]
+		if keep[mountpoint] || underAny(mountpoint, writable) {
+			continue
+		}
+		// Flags locked by the user namespace must be kept on remount
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "nosuid":
+				flags |= syscall.MS_NOSUID
+			case "nodev":
+				flags |= syscall.MS_NODEV
+			case "noexec":
+				flags |= syscall.MS_NOEXEC
+			case "noatime":
+				flags |= syscall.MS_NOATIME
+			case "nodiratime":
+				flags |= syscall.MS_NODIRATIME
+			case "relatime":
+				flags |= syscall.MS_RELATIME
+			}
+		}
+		if err := syscall.Mount("", mountpoint, "", flags, ""); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("remounting %s read-only: %v", mountpoint, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// unescapeMountinfo decodes the octal escapes the kernel writes for space,
+// tab, newline and backslash in the paths of /proc/self/mountinfo, e.g.
+// \040 for a space.
+func unescapeMountinfo(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the re-executed launcher. The configuration
+// and the action are handed over in the environment.
+const (
+	testConfigEnv = "HOOK_LAUNCHER_TEST_CONFIG"
+	testArgsEnv   = "HOOK_LAUNCHER_TEST_ARGS"
+)
+
+func TestMain(m *testing.M) {
+	if IsInit() {
+		var (
+			cfg  Config
+			args []string
+		)
+		json.Unmarshal([]byte(os.Getenv(testConfigEnv)), &cfg)
+		json.Unmarshal([]byte(os.Getenv(testArgsEnv)), &args)
+		err := Init(&cfg, "test", args)
+		err.(*Error).Report(os.Stderr)
+		os.Exit(ExitSetupFailed)
+	}
+	os.Exit(m.Run())
+}
+
+func launch(cfg *Config, args ...string) (int, string, error) {
+	data, _ := json.Marshal(cfg)
+	os.Setenv(testConfigEnv, string(data))
+	data, _ = json.Marshal(args)
+	os.Setenv(testArgsEnv, string(data))
+	defer os.Unsetenv(testConfigEnv)
+	defer os.Unsetenv(testArgsEnv)
+
+	var stdout bytes.Buffer
+	code, err := Run(cfg, "test", args, &stdout)
+	return code, stdout.String(), err
+}
+
+func TestRunPassesExitCodeAndOutput(t *testing.T) {
+	code, stdout, err := launch(&Config{}, "sh", "-c", "echo response; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 || stdout != "response\n" {
+		t.Fatalf("got exit code %d, output %q", code, stdout)
+	}
+}
+
+func TestRunTimeoutReplacesOutput(t *testing.T) {
+	cfg := &Config{Timeout: Duration(200 * time.Millisecond)}
+	code, stdout, err := launch(cfg, "sh", "-c", "echo partial; sleep 10")
+	e, ok := err.(*Error)
+	if !ok || e.Code != CodeTimeout || code != ExitTimeout {
+		t.Fatalf("got exit code %d, error %v", code, err)
+	}
+	var response struct{ Error Error }
+	if err := json.Unmarshal([]byte(stdout), &response); err != nil || response.Error.Code != CodeTimeout {
+		t.Fatalf("output %q is not the timeout error", stdout)
+	}
+}
+
+func TestRunSyscallViolation(t *testing.T) {
+	// Not even the dynamic loader of sh gets by with the required syscalls
+	cfg := &Config{Seccomp: Seccomp{Enabled: true, DefaultAction: "kill"}}
+	code, stdout, err := launch(cfg, "sh", "-c", "echo escaped")
+	e, ok := err.(*Error)
+	if ok && e.Code == CodeSetupFailed {
+		t.Skipf("seccomp is not available: %v", e)
+	}
+	if !ok || e.Code != CodeSyscallViolation {
+		t.Fatalf("got exit code %d, error %v, output %q", code, err, stdout)
+	}
+	var response struct{ Error Error }
+	if err := json.Unmarshal([]byte(stdout), &response); err != nil || response.Error.Code != CodeSyscallViolation {
+		t.Fatalf("output %q is not the violation error", stdout)
+	}
+}
+
+func TestRunOutputLimit(t *testing.T) {
+	cfg := &Config{MaxOutputMB: 1}
+	code, stdout, err := launch(cfg, "sh", "-c", "head -c 2000000 /dev/zero")
+	e, ok := err.(*Error)
+	if !ok || e.Code != CodeResourceLimitExceeded || code != ExitOutputLimitExceeded {
+		t.Fatalf("got exit code %d, error %v", code, err)
+	}
+	var response struct{ Error Error }
+	if err := json.Unmarshal([]byte(stdout), &response); err != nil || response.Error.Code != CodeResourceLimitExceeded {
+		t.Fatalf("output of %d bytes is not the limit error", len(stdout))
+	}
+
+	code, stdout, err = launch(cfg, "sh", "-c", "head -c 1000 /dev/zero")
+	if err != nil || code != 0 || len(stdout) != 1000 {
+		t.Fatalf("got exit code %d, error %v, %d bytes of output", code, err, len(stdout))
+	}
+}
+
+func TestRunRlimits(t *testing.T) {
+	code, stdout, err := launch(&Config{Rlimits: Rlimits{OpenFiles: 64}}, "sh", "-c", "ulimit -n")
+	if err != nil || code != 0 || stdout != "64\n" {
+		t.Fatalf("got exit code %d, error %v, output %q", code, err, stdout)
+	}
+
+	dir, err := ioutil.TempDir("", "launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Rlimits: Rlimits{FileSizeMB: 1}}
+	code, _, err = launch(cfg, "sh", "-c", "exec head -c 2000000 /dev/zero >"+filepath.Join(dir, "big"))
+	if e, ok := err.(*Error); !ok || e.Code != CodeResourceLimitExceeded {
+		t.Fatalf("got exit code %d, error %v", code, err)
+	}
+}
+
+func TestRunReadOnlyRoot(t *testing.T) {
+	writable, err := ioutil.TempDir("", "launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(writable)
+	other, err := ioutil.TempDir("", "launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+
+	cfg := &Config{ReadOnlyRoot: true, WritablePaths: []string{writable}}
+	code, stdout, err := launch(cfg, "sh", "-c", "touch "+filepath.Join(writable, "ok")+"; touch "+filepath.Join(other, "denied")+" 2>/dev/null")
+	if e, ok := err.(*Error); ok && e.Code == CodeSetupFailed {
+		t.Skipf("user namespaces are not available: %v", e)
+	}
+	if err != nil {
+		t.Fatalf("got exit code %d, error %v, output %q", code, err, stdout)
+	}
+	if _, err := os.Stat(filepath.Join(writable, "ok")); err != nil {
+		t.Errorf("writable path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(other, "denied")); err == nil {
+		t.Error("file written outside of the writable paths")
+	}
+}
+
+func TestUnescapeMountinfo(t *testing.T) {
+	for escaped, want := range map[string]string{
+		"/var/vcap/data":             "/var/vcap/data",
+		`/mnt/with\040space`:         "/mnt/with space",
+		`/mnt/tab\011and\012newline`: "/mnt/tab\tand\nnewline",
+		`/mnt/back\134slash`:         `/mnt/back\slash`,
+		`/mnt/not\08`:                `/mnt/not\08`,
+	} {
+		if got := unescapeMountinfo(escaped); got != want {
+			t.Errorf("unescapeMountinfo(%q) = %q, want %q", escaped, got, want)
+		}
+	}
+}
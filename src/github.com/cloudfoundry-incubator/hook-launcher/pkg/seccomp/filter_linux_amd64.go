@@ -0,0 +1,148 @@
+// Package seccomp builds and installs a seccomp-bpf filter which only
+// allows a whitelist of syscalls.
+package seccomp
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// Actions taken for syscalls which are not whitelisted.
+const (
+	ActionKill  = "kill"
+	ActionErrno = "errno"
+	ActionLog   = "log"
+)
+
+const (
+	bpfLD  = 0x00
+	bpfW   = 0x00
+	bpfABS = 0x20
+	bpfJMP = 0x05
+	bpfJEQ = 0x10
+	bpfJGE = 0x30
+	bpfK   = 0x00
+	bpfRET = 0x06
+
+	retKillProcess = 0x80000000
+	retErrno       = 0x00050000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+
+	auditArchX86_64 = 0xc000003e
+	x32SyscallBit   = 0x40000000
+
+	// offsets into struct seccomp_data
+	offsetNr   = 0
+	offsetArch = 4
+
+	prSetNoNewPrivs   = 38
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+)
+
+// Required are the syscalls always allowed. The launcher execs the action
+// right after installing the filter, but the Go runtime may still handle a
+// signal or park the locked thread in between.
+var Required = []string{
+	"execve",
+	"exit", "exit_group",
+	"rt_sigreturn", "rt_sigprocmask", "sigaltstack",
+	"futex", "sched_yield", "nanosleep",
+	"getpid", "gettid", "tgkill",
+}
+
+type sockFilter struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+type sockFprog struct {
+	Len    uint16
+	_      [6]byte
+	Filter *sockFilter
+}
+
+// Filter is a compiled seccomp-bpf program.
+type Filter struct {
+	program []sockFilter
+}
+
+// Unknown returns the names which are not x86_64 syscalls.
+func Unknown(names []string) []string {
+	var unknown []string
+	for _, name := range names {
+		if _, ok := syscalls[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// New compiles a filter allowing the given syscalls and Required, any
+// other syscall is handled according to action.
+func New(allowed []string, action string) (*Filter, error) {
+	var defaultRet uint32
+	switch action {
+	case ActionKill:
+		defaultRet = retKillProcess
+	case ActionErrno:
+		defaultRet = retErrno | uint32(syscall.EPERM)
+	case ActionLog:
+		defaultRet = retLog
+	default:
+		return nil, fmt.Errorf("unknown seccomp action %q", action)
+	}
+	if unknown := Unknown(allowed); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown syscalls %v", unknown)
+	}
+
+	numbers := map[uint32]bool{}
+	for _, name := range append(allowed, Required...) {
+		numbers[syscalls[name]] = true
+	}
+	sorted := make([]uint32, 0, len(numbers))
+	for nr := range numbers {
+		sorted = append(sorted, nr)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	program := []sockFilter{
+		// Only x86_64 syscalls, no x32 or i386 ABI
+		{Code: bpfLD | bpfW | bpfABS, K: offsetArch},
+		{Code: bpfJMP | bpfJEQ | bpfK, Jt: 1, Jf: 0, K: auditArchX86_64},
+		{Code: bpfRET | bpfK, K: retKillProcess},
+		{Code: bpfLD | bpfW | bpfABS, K: offsetNr},
+		{Code: bpfJMP | bpfJGE | bpfK, Jt: 0, Jf: 1, K: x32SyscallBit},
+		{Code: bpfRET | bpfK, K: retKillProcess},
+	}
+	for _, nr := range sorted {
+		program = append(program,
+			sockFilter{Code: bpfJMP | bpfJEQ | bpfK, Jt: 0, Jf: 1, K: nr},
+			sockFilter{Code: bpfRET | bpfK, K: retAllow},
+		)
+	}
+	program = append(program, sockFilter{Code: bpfRET | bpfK, K: defaultRet})
+	return &Filter{program: program}, nil
+}
+
+// Install sets no_new_privs and installs the filter on the calling thread.
+// The caller must hold runtime.LockOSThread and exec right afterwards, the
+// filter is inherited by the executed program.
+func (f *Filter) Install() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %v", errno)
+	}
+	prog := sockFprog{
+		Len:    uint16(len(f.program)),
+		Filter: &f.program[0],
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("installing seccomp filter: %v", errno)
+	}
+	return nil
+}
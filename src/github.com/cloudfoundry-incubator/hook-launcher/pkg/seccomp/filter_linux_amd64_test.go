@@ -0,0 +1,36 @@
+package seccomp
+
+import "testing"
+
+func TestNewRejectsUnknownSyscalls(t *testing.T) {
+	if _, err := New([]string{"read", "no_such_syscall"}, ActionKill); err == nil {
+		t.Fatal("unknown syscall accepted")
+	}
+	if _, err := New([]string{"read"}, "ignore"); err == nil {
+		t.Fatal("unknown action accepted")
+	}
+}
+
+func TestNewAllowsRequired(t *testing.T) {
+	f, err := New([]string{"read", "write", "read"}, ActionErrno)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := map[uint32]bool{}
+	for i, insn := range f.program[:len(f.program)-1] {
+		if insn.Code == bpfJMP|bpfJEQ|bpfK && f.program[i+1].K == retAllow {
+			allowed[insn.K] = true
+		}
+	}
+	for _, name := range append([]string{"read", "write"}, Required...) {
+		if !allowed[syscalls[name]] {
+			t.Errorf("%s is not allowed", name)
+		}
+	}
+	if len(allowed) != len(Required)+2 {
+		t.Errorf("%d syscalls allowed, want %d", len(allowed), len(Required)+2)
+	}
+	if last := f.program[len(f.program)-1]; last.Code != bpfRET|bpfK || last.K != retErrno|1 {
+		t.Errorf("default action %#x, want errno EPERM", last.K)
+	}
+}
@@ -0,0 +1,108 @@
+// iptables-manager owns a dedicated iptables chain jumped to from INPUT,
+// fills it with the rules built from the allow and block lists and repairs
+// it whenever it drifts. If configured, a second chain jumped to from
+// DOCKER-USER holds the rules built from the bind resources on the
+// apiserver for the published ports of the instances.
+// On SIGTERM it removes its chains and leaves all other rules untouched.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/binds"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/config"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/reconciler"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/rules"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/iptables-manager/config/config.json", "path to the configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading configuration: %v", err)
+	}
+	allowed, blocked, err := staticRules(cfg)
+	if err != nil {
+		log.Fatalf("parsing allow and block lists: %v", err)
+	}
+
+	executor := &iptables.Executor{Command: cfg.Command, Wait: true}
+	reconcilers := []*reconciler.Reconciler{reconciler.New(cfg.Chain, cfg.From, executor, allowed, blocked)}
+	var bindSource *binds.Source
+	if cfg.Binds != nil && !cfg.EnableConnection {
+		if bindSource, err = binds.New(*cfg.Binds); err != nil {
+			log.Fatalf("configuring bind rules: %v", err)
+		}
+		reconcilers = append(reconcilers, reconciler.New(cfg.Binds.Chain, cfg.Binds.From, executor, bindSource))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if bindSource != nil {
+		go bindSource.Run(ctx, reconcilers[1].Trigger)
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range reconcilers {
+		wg.Add(1)
+		go func(r *reconciler.Reconciler) {
+			defer wg.Done()
+			log.Printf("reconciling chain %s every %s", r.Chain, time.Duration(cfg.Interval))
+			r.Run(ctx, time.Duration(cfg.Interval))
+		}(r)
+	}
+	wg.Wait()
+	failed := false
+	for _, r := range reconcilers {
+		if err := r.Cleanup(); err != nil {
+			log.Printf("removing chain %s: %v", r.Chain, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// staticRules returns the accept rules (DNS answers and the allow list) and
+// the drop rules (block list). They stay empty with enable_connection.
+func staticRules(cfg *config.Config) (reconciler.Static, reconciler.Static, error) {
+	if cfg.EnableConnection {
+		return nil, nil, nil
+	}
+	allow, err := rules.ParseList(cfg.AllowList)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := rules.ParseList(cfg.BlockList)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowed := reconciler.Static{
+		{Protocol: "udp", SourcePort: "53", Target: rules.Accept},
+		{Protocol: "tcp", SourcePort: "53", Target: rules.Accept},
+	}
+	for _, entry := range allow {
+		allowed = append(allowed, rules.ForEntry(entry, rules.Accept)...)
+	}
+	var blocked reconciler.Static
+	for _, entry := range block {
+		blocked = append(blocked, rules.ForEntry(entry, rules.Drop)...)
+	}
+	return allowed, blocked, nil
+}
@@ -0,0 +1,312 @@
+// Package binds watches the bind resources on the Service Fabrik apiserver
+// and turns the endpoints of bound instances into firewall rules.
+//
+// Instances are containers with ports published by Docker. Their traffic is
+// DNATed in PREROUTING and passes the FORWARD chain, not INPUT, so the
+// rules belong in a chain jumped to from DOCKER-USER and match the
+// published host address and port with conntrack, as the destination port
+// of the packets is the container port by then. DirectorBind instances are
+// BOSH VMs of their own and are not covered.
+//
+// A binding opens nothing unless the broker records spec.allowedCidrs and
+// status.endpoints in its resource.
+package binds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/rules"
+)
+
+// stateSucceeded is the state of a binding whose credentials were handed out.
+const stateSucceeded = "succeeded"
+
+// ErrNotSynced is returned by Rules until all resources were listed once.
+var ErrNotSynced = errors.New("bind resources not listed yet")
+
+// Config configures the bind driven rules.
+type Config struct {
+	// Chain is the chain owned by the iptables-manager for the bind rules.
+	Chain string `json:"chain"`
+	// From is the chain jumping to Chain, DOCKER-USER.
+	From string `json:"from"`
+	// URL and CA of the Service Fabrik apiserver. Token only needs to
+	// get, list and watch the resources.
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	CA    string `json:"ca"`
+	// Resources are the plural names of the bind.servicefabrik.io/v1alpha1
+	// resources to watch, e.g. dockerbinds.
+	Resources []string `json:"resources"`
+	// HostIPs are the IPs of this VM, only endpoints on them are opened.
+	HostIPs []string `json:"host_ips"`
+}
+
+type bind struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		AllowedCIDRs []string `json:"allowedCidrs"`
+	} `json:"spec"`
+	Status struct {
+		State     string `json:"state"`
+		Endpoints []struct {
+			Host  string `json:"host"`
+			Ports []int  `json:"ports"`
+		} `json:"endpoints"`
+	} `json:"status"`
+}
+
+type event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Source keeps the bind resources in sync through list and watch calls and
+// provides the rules for their endpoints on this VM.
+type Source struct {
+	config  Config
+	client  *http.Client
+	hostIPs map[string]bool
+
+	mu     sync.RWMutex
+	binds  map[string]map[string]bind // resource -> namespace/name -> bind
+	synced map[string]bool
+}
+
+// New returns the source for cfg.
+func New(cfg Config) (*Source, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CA != "" {
+		ca, err := ioutil.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	hostIPs := map[string]bool{}
+	for _, ip := range cfg.HostIPs {
+		hostIPs[ip] = true
+	}
+	return &Source{
+		config:  cfg,
+		client:  &http.Client{Transport: transport},
+		hostIPs: hostIPs,
+		binds:   map[string]map[string]bind{},
+		synced:  map[string]bool{},
+	}, nil
+}
+
+// Run lists and watches all resources until ctx is done. trigger is
+// signalled whenever a binding changed.
+func (s *Source) Run(ctx context.Context, trigger chan<- struct{}) {
+	var wg sync.WaitGroup
+	for _, resource := range s.config.Resources {
+		wg.Add(1)
+		go func(resource string) {
+			defer wg.Done()
+			for {
+				err := s.listAndWatch(ctx, resource, trigger)
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil {
+					// The apiserver ended the watch, list again
+					continue
+				}
+				log.Printf("watching %s failed: %v", resource, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}(resource)
+	}
+	wg.Wait()
+}
+
+// listAndWatch lists resource and applies the watch events until the watch
+// fails, or returns nil when the apiserver ends it after its timeout.
+func (s *Source) listAndWatch(ctx context.Context, resource string, trigger chan<- struct{}) error {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []bind `json:"items"`
+	}
+	resp, err := s.get(ctx, resource, "")
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	binds := map[string]bind{}
+	for _, b := range list.Items {
+		binds[key(b)] = b
+	}
+	s.mu.Lock()
+	s.binds[resource] = binds
+	s.synced[resource] = true
+	s.mu.Unlock()
+	notify(trigger)
+
+	resp, err = s.get(ctx, resource, "?watch=1&resourceVersion="+list.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var e event
+		if err := decoder.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var b bind
+		if e.Type == "ERROR" || json.Unmarshal(e.Object, &b) != nil {
+			// e.g. the resource version is too old, list again
+			return fmt.Errorf("watch event %s: %s", e.Type, e.Object)
+		}
+		s.mu.Lock()
+		switch e.Type {
+		case "ADDED", "MODIFIED":
+			s.binds[resource][key(b)] = b
+		case "DELETED":
+			delete(s.binds[resource], key(b))
+		}
+		s.mu.Unlock()
+		notify(trigger)
+	}
+}
+
+func (s *Source) get(ctx context.Context, resource, query string) (*http.Response, error) {
+	url := s.config.URL + "/apis/bind.servicefabrik.io/v1alpha1/" + resource + query
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// Rules lets traffic to the published ports of succeeded bindings on this
+// VM pass on to Docker's rules from their allowed CIDRs, and drops all
+// other traffic to these ports.
+func (s *Source) Rules() ([]rules.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, resource := range s.config.Resources {
+		if !s.synced[resource] {
+			return nil, ErrNotSynced
+		}
+	}
+
+	var keys []string
+	all := map[string]bind{}
+	for resource, binds := range s.binds {
+		for k, b := range binds {
+			keys = append(keys, resource+"/"+k)
+			all[resource+"/"+k] = b
+		}
+	}
+	sort.Strings(keys)
+
+	var allowed []rules.Rule
+	granted := map[endpoint]bool{}
+	for _, k := range keys {
+		b := all[k]
+		if b.Status.State != stateSucceeded {
+			continue
+		}
+		for _, e := range b.Status.Endpoints {
+			if !s.hostIPs[e.Host] {
+				continue
+			}
+			for _, port := range e.Ports {
+				granted[endpoint{e.Host, port}] = true
+				for _, cidr := range b.Spec.AllowedCIDRs {
+					source, err := rules.NormalizeSource(cidr)
+					if err != nil {
+						log.Printf("binding %s: skipping CIDR: %v", k, err)
+						continue
+					}
+					allowed = append(allowed, published(source, e.Host, strconv.Itoa(port), rules.Return)...)
+				}
+			}
+		}
+	}
+
+	endpoints := make([]endpoint, 0, len(granted))
+	for e := range granted {
+		endpoints = append(endpoints, e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].host != endpoints[j].host {
+			return endpoints[i].host < endpoints[j].host
+		}
+		return endpoints[i].port < endpoints[j].port
+	})
+	var drops []rules.Rule
+	for _, e := range endpoints {
+		drops = append(drops, published("", e.host, strconv.Itoa(e.port), rules.Drop)...)
+	}
+	return append(allowed, drops...), nil
+}
+
+type endpoint struct {
+	host string
+	port int
+}
+
+// published returns the TCP and UDP rules for traffic from source to the
+// host address and port Docker published an instance port on.
+func published(source, host, port, target string) []rules.Rule {
+	return []rules.Rule{
+		{Source: source, Protocol: "tcp", OrigDest: host + "/32", OrigDestPort: port, Target: target},
+		{Source: source, Protocol: "udp", OrigDest: host + "/32", OrigDestPort: port, Target: target},
+	}
+}
+
+func key(b bind) string {
+	return b.Metadata.Namespace + "/" + b.Metadata.Name
+}
+
+func notify(trigger chan<- struct{}) {
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,158 @@
+package binds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBind(namespace, name, state, host string, ports []int, cidrs ...string) bind {
+	var b bind
+	b.Metadata.Namespace, b.Metadata.Name = namespace, name
+	b.Spec.AllowedCIDRs = cidrs
+	b.Status.State = state
+	b.Status.Endpoints = append(b.Status.Endpoints, struct {
+		Host  string `json:"host"`
+		Ports []int  `json:"ports"`
+	}{host, ports})
+	return b
+}
+
+func render(t *testing.T, s *Source) []string {
+	rules, err := s.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, rule := range rules {
+		lines = append(lines, rule.Render("B"))
+	}
+	return lines
+}
+
+func TestRulesNotSynced(t *testing.T) {
+	s, err := New(Config{Resources: []string{"dockerbinds", "otherbinds"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.binds["dockerbinds"], s.synced["dockerbinds"] = map[string]bind{}, true
+	if _, err := s.Rules(); err != ErrNotSynced {
+		t.Fatalf("got %v with otherbinds not listed, want %v", err, ErrNotSynced)
+	}
+}
+
+func TestRules(t *testing.T) {
+	s, err := New(Config{
+		Resources: []string{"dockerbinds"},
+		HostIPs:   []string{"10.1.0.5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.synced["dockerbinds"] = true
+	s.binds["dockerbinds"] = map[string]bind{}
+	for _, b := range []bind{
+		newBind("sf", "b1", stateSucceeded, "10.1.0.5", []int{32768}, "10.0.0.0/24", "bogus"),
+		newBind("sf", "b2", stateSucceeded, "10.1.0.5", []int{32768}, "192.168.1.7"),
+		// Not handed out yet, and an instance on another VM
+		newBind("sf", "b3", "in_progress", "10.1.0.5", []int{32800}, "0.0.0.0/0"),
+		newBind("sf", "b4", stateSucceeded, "10.1.0.6", []int{32801}, "0.0.0.0/0"),
+	} {
+		s.binds["dockerbinds"][key(b)] = b
+	}
+
+	want := []string{
+		"-A B -s 10.0.0.0/24 -p tcp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j RETURN",
+		"-A B -s 10.0.0.0/24 -p udp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j RETURN",
+		"-A B -s 192.168.1.7/32 -p tcp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j RETURN",
+		"-A B -s 192.168.1.7/32 -p udp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j RETURN",
+		"-A B -p tcp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j DROP",
+		"-A B -p udp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j DROP",
+	}
+	got := render(t, s)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunListsAndWatches(t *testing.T) {
+	events := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer read-only" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, `{"metadata": {"resourceVersion": "1"}, "items": []}`)
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	s, err := New(Config{URL: server.URL, Token: "read-only", Resources: []string{"dockerbinds"}, HostIPs: []string{"10.1.0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trigger := make(chan struct{}, 1)
+	go s.Run(ctx, trigger)
+
+	wait := func() {
+		select {
+		case <-trigger:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no trigger")
+		}
+	}
+	wait()
+	if rules := render(t, s); len(rules) != 0 {
+		t.Fatalf("rules without bindings: %v", rules)
+	}
+
+	object, _ := json.Marshal(newBind("sf", "b1", stateSucceeded, "10.1.0.5", []int{32768}, "10.0.0.0/24"))
+	events <- fmt.Sprintf(`{"type": "ADDED", "object": %s}`, object)
+	wait()
+	if rules := render(t, s); len(rules) != 4 {
+		t.Fatalf("got %d rules for one binding, want 4: %v", len(rules), rules)
+	}
+}
+
+func TestListAndWatchEndsWithWatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, `{"metadata": {"resourceVersion": "1"}, "items": []}`)
+			return
+		}
+		// The apiserver ends a watch after its timeout
+		object, _ := json.Marshal(newBind("sf", "b1", stateSucceeded, "10.1.0.5", []int{32768}, "10.0.0.0/24"))
+		fmt.Fprintf(w, `{"type": "ADDED", "object": %s}`+"\n", object)
+	}))
+	defer server.Close()
+
+	s, err := New(Config{URL: server.URL, Resources: []string{"dockerbinds"}, HostIPs: []string{"10.1.0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.listAndWatch(context.Background(), "dockerbinds", make(chan struct{}, 1)); err != nil {
+		t.Fatalf("ended watch reported as failure: %v", err)
+	}
+	if rules := render(t, s); len(rules) != 4 {
+		t.Fatalf("got %d rules for the watched binding, want 4: %v", len(rules), rules)
+	}
+}
@@ -0,0 +1,90 @@
+// Package config loads the iptables-manager configuration rendered by the
+// iptables-manager BOSH job.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/binds"
+)
+
+// Config is the iptables-manager configuration.
+type Config struct {
+	// Chain is the chain owned by the iptables-manager.
+	Chain string `json:"chain"`
+	// From is the built-in chain jumping to Chain.
+	From string `json:"from"`
+	// Interval is the time between two drift checks.
+	Interval Duration `json:"interval"`
+	// EnableConnection leaves the chain empty, i.e. allows all traffic.
+	EnableConnection bool `json:"enable_connection"`
+	// AllowList and BlockList are comma separated entries of the form
+	// <ip>[/<prefix>][:<port>[-<port>]].
+	AllowList string `json:"allow_list"`
+	BlockList string `json:"block_list"`
+	// Binds enables rules for the endpoints of bound instances.
+	Binds *binds.Config `json:"binds,omitempty"`
+	// Command is prepended to the iptables invocations, e.g. to run them
+	// in a network namespace.
+	Command []string `json:"command,omitempty"`
+}
+
+// Duration is a time.Duration read from a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Chain:    "SF-IPTABLES-MANAGER",
+		From:     "INPUT",
+		Interval: Duration(30 * time.Second),
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if len(cfg.Chain) > 28 {
+		return nil, fmt.Errorf("chain name %q is longer than 28 characters", cfg.Chain)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	if cfg.Binds != nil {
+		if cfg.Binds.Chain == "" {
+			cfg.Binds.Chain = cfg.Chain + "-BINDS"
+		}
+		if cfg.Binds.From == "" {
+			cfg.Binds.From = "DOCKER-USER"
+		}
+		if len(cfg.Binds.Chain) > 28 {
+			return nil, fmt.Errorf("chain name %q is longer than 28 characters", cfg.Binds.Chain)
+		}
+		if cfg.Binds.Chain == cfg.Chain {
+			return nil, fmt.Errorf("binds chain must differ from %s", cfg.Chain)
+		}
+		if cfg.Binds.Token == "" {
+			return nil, fmt.Errorf("binds token is required")
+		}
+	}
+	return cfg, nil
+}
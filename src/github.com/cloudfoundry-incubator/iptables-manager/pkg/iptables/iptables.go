@@ -0,0 +1,110 @@
+// Package iptables runs the iptables tools for the chain owned by the
+// iptables-manager.
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Executor runs the iptables commands. Pointing it at a network namespace,
+// e.g. Command: []string{"ip", "netns", "exec", "test"}, keeps the host
+// firewall untouched.
+type Executor struct {
+	// Command is prepended to every iptables invocation.
+	Command []string
+	// Wait is passed as -w to wait for the xtables lock.
+	Wait bool
+
+	// mu serializes the invocations of the reconcilers sharing the
+	// executor, iptables-restore cannot wait for the xtables lock
+	mu sync.Mutex
+}
+
+func (e *Executor) run(stdin string, name string, args ...string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	argv := append(append([]string{}, e.Command...), name)
+	// iptables-restore only supports -w since iptables 1.6.2
+	if e.Wait && name == "iptables" {
+		argv = append(argv, "-w")
+	}
+	argv = append(argv, args...)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v: %s", strings.Join(argv, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Restore applies the iptables-restore input atomically without flushing
+// the chains it does not mention.
+func (e *Executor) Restore(input string) error {
+	_, err := e.run(input, "iptables-restore", "--noflush")
+	return err
+}
+
+// ChainRules returns the rules of chain as printed by iptables-save, and
+// whether the chain exists.
+func (e *Executor) ChainRules(chain string) ([]string, bool, error) {
+	// iptables-save has no -w, it does not take the xtables lock
+	argv := append(append([]string{}, e.Command...), "iptables-save", "-t", "filter")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out, err := exec.Command(argv[0], argv[1:]...).Output()
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", strings.Join(argv, " "), err)
+	}
+	var (
+		rules  []string
+		exists bool
+	)
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, ":"+chain+" ") {
+			exists = true
+		}
+		if strings.HasPrefix(line, "-A "+chain+" ") {
+			rules = append(rules, line)
+		}
+	}
+	return rules, exists, nil
+}
+
+// HasJump reports whether from jumps to chain.
+func (e *Executor) HasJump(from, chain string) bool {
+	_, err := e.run("", "iptables", "-C", from, "-j", chain)
+	return err == nil
+}
+
+// InsertJump makes chain the first rule of from.
+func (e *Executor) InsertJump(from, chain string) error {
+	_, err := e.run("", "iptables", "-I", from, "1", "-j", chain)
+	return err
+}
+
+// DeleteChain removes all jumps from from to chain and deletes chain. A
+// chain which does not exist, e.g. on a second cleanup, is not an error.
+func (e *Executor) DeleteChain(from, chain string) error {
+	for e.HasJump(from, chain) {
+		if _, err := e.run("", "iptables", "-D", from, "-j", chain); err != nil {
+			return err
+		}
+	}
+	// iptables -F and -X fail for a missing chain
+	if _, exists, err := e.ChainRules(chain); err != nil || !exists {
+		return err
+	}
+	if _, err := e.run("", "iptables", "-F", chain); err != nil {
+		return err
+	}
+	_, err := e.run("", "iptables", "-X", chain)
+	return err
+}
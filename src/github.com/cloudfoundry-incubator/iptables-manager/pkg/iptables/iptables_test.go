@@ -0,0 +1,67 @@
+package iptables_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables/iptablestest"
+)
+
+func TestMain(m *testing.M) {
+	iptablestest.Main(m)
+}
+
+func TestExecutorChainRules(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	e := fake.Executor()
+
+	if _, exists, err := e.ChainRules("SF-TEST"); err != nil || exists {
+		t.Fatalf("missing chain: exists %t, %v", exists, err)
+	}
+	err := e.Restore("*filter\n:SF-TEST - [0:0]\n-A SF-TEST -p tcp -j DROP\n-A SF-TEST -j ACCEPT\nCOMMIT\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, exists, err := e.ChainRules("SF-TEST")
+	if err != nil || !exists {
+		t.Fatalf("restored chain: exists %t, %v", exists, err)
+	}
+	if strings.Join(rules, "\n") != "-A SF-TEST -p tcp -j DROP\n-A SF-TEST -j ACCEPT" {
+		t.Fatalf("got rules %q", rules)
+	}
+}
+
+func TestExecutorJumps(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	e := fake.Executor()
+
+	if err := e.InsertJump("INPUT", "SF-TEST"); err == nil {
+		t.Fatal("jump to a missing chain inserted")
+	}
+	if err := e.Restore("*filter\n:SF-TEST - [0:0]\n-A SF-TEST -j ACCEPT\nCOMMIT\n"); err != nil {
+		t.Fatal(err)
+	}
+	if e.HasJump("INPUT", "SF-TEST") {
+		t.Fatal("jump reported before it was inserted")
+	}
+	for i := 0; i < 2; i++ {
+		if err := e.InsertJump("INPUT", "SF-TEST"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !e.HasJump("INPUT", "SF-TEST") {
+		t.Fatal("inserted jump not reported")
+	}
+
+	if err := e.DeleteChain("INPUT", "SF-TEST"); err != nil {
+		t.Fatal(err)
+	}
+	if e.HasJump("INPUT", "SF-TEST") {
+		t.Error("jump left after deleting the chain")
+	}
+	if _, exists, _ := e.ChainRules("SF-TEST"); exists {
+		t.Error("chain left after deleting it")
+	}
+}
@@ -0,0 +1,259 @@
+// Package iptablestest fakes the iptables tools for tests of the
+// iptables-manager. The filter table is kept in a file instead of the
+// kernel, so the tests need neither root nor a network namespace.
+package iptablestest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables"
+)
+
+const stateEnv = "IPTABLESTEST_STATE"
+
+// Chain is a chain of the fake filter table with its rules in
+// iptables-save syntax, without the "-A <chain>" prefix.
+type Chain struct {
+	Name    string
+	BuiltIn bool
+	Rules   []string
+}
+
+// Table is the fake filter table.
+type Table struct {
+	Chains []*Chain
+	// Restores counts the iptables-restore calls.
+	Restores int
+}
+
+func (t *Table) chain(name string) *Chain {
+	for _, c := range t.Chains {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Main runs the fake tool instead of the tests when the test binary is
+// invoked by an executor of New. TestMain of the tests using New must
+// call it.
+func Main(m *testing.M) {
+	if path := os.Getenv(stateEnv); path != "" {
+		code, err := run(path, os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
+
+// Fake is a filter table shared by the executors it returns.
+type Fake struct {
+	t    *testing.T
+	path string
+}
+
+// New returns a fake filter table with the built-in chains and the
+// given custom chains, e.g. DOCKER-USER.
+func New(t *testing.T, chains ...string) *Fake {
+	dir, err := ioutil.TempDir("", "iptablestest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fake{t: t, path: filepath.Join(dir, "table.json")}
+	table := &Table{}
+	for _, name := range []string{"INPUT", "FORWARD", "OUTPUT"} {
+		table.Chains = append(table.Chains, &Chain{Name: name, BuiltIn: true})
+	}
+	for _, name := range chains {
+		table.Chains = append(table.Chains, &Chain{Name: name})
+	}
+	f.Write(table)
+	return f
+}
+
+// Executor returns an executor running the fake tools.
+func (f *Fake) Executor() *iptables.Executor {
+	self, err := os.Executable()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return &iptables.Executor{Command: []string{"env", stateEnv + "=" + f.path, self}, Wait: true}
+}
+
+// Table returns the current content of the table.
+func (f *Fake) Table() *Table {
+	table, err := load(f.path)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return table
+}
+
+// Write replaces the content of the table, e.g. to make it drift.
+func (f *Fake) Write(table *Table) {
+	if err := save(f.path, table); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// Close removes the table.
+func (f *Fake) Close() {
+	os.RemoveAll(filepath.Dir(f.path))
+}
+
+func load(path string) (*Table, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{}
+	return table, json.Unmarshal(data, table)
+}
+
+func save(path string, table *Table) error {
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Errors as printed by iptables.
+const (
+	noChain = "iptables: No chain/target/match by that name."
+	badRule = "iptables: Bad rule (does a matching rule exist in that chain?)."
+)
+
+func run(path, tool string, args []string) (int, error) {
+	table, err := load(path)
+	if err != nil {
+		return 2, err
+	}
+	code, err := 0, error(nil)
+	switch tool {
+	case "iptables-save":
+		save := bufio.NewWriter(os.Stdout)
+		fmt.Fprintln(save, "*filter")
+		for _, c := range table.Chains {
+			policy := "-"
+			if c.BuiltIn {
+				policy = "ACCEPT"
+			}
+			fmt.Fprintf(save, ":%s %s [0:0]\n", c.Name, policy)
+		}
+		for _, c := range table.Chains {
+			for _, rule := range c.Rules {
+				fmt.Fprintf(save, "-A %s %s\n", c.Name, rule)
+			}
+		}
+		fmt.Fprintln(save, "COMMIT")
+		return 0, save.Flush()
+	case "iptables-restore":
+		table.Restores++
+		code, err = restore(table)
+	case "iptables":
+		code, err = iptablesCmd(table, args)
+	default:
+		return 2, fmt.Errorf("%s: not faked", tool)
+	}
+	if code != 0 {
+		return code, err
+	}
+	return 0, save(path, table)
+}
+
+func restore(table *Table) (int, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ":"):
+			name := strings.Fields(line[1:])[0]
+			if c := table.chain(name); c != nil {
+				c.Rules = nil
+			} else {
+				table.Chains = append(table.Chains, &Chain{Name: name})
+			}
+		case strings.HasPrefix(line, "-A "):
+			fields := strings.SplitN(line, " ", 3)
+			c := table.chain(fields[1])
+			if c == nil {
+				return 1, fmt.Errorf("iptables-restore: chain %s does not exist", fields[1])
+			}
+			c.Rules = append(c.Rules, fields[2])
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// targets are the targets which are not chains.
+var targets = map[string]bool{"ACCEPT": true, "DROP": true, "RETURN": true, "REJECT": true, "LOG": true}
+
+func iptablesCmd(table *Table, args []string) (int, error) {
+	var command []string
+	for _, arg := range args {
+		if arg != "-w" {
+			command = append(command, arg)
+		}
+	}
+	if len(command) < 2 {
+		return 2, fmt.Errorf("iptables %s: not faked", strings.Join(args, " "))
+	}
+	c := table.chain(command[1])
+	if c == nil {
+		return 1, errors.New(noChain)
+	}
+	rule := strings.Join(command[2:], " ")
+	if target := command[len(command)-1]; command[len(command)-2] == "-j" && table.chain(target) == nil && !targets[target] {
+		return 1, errors.New(noChain)
+	}
+	switch command[0] {
+	case "-C", "-D":
+		for i, r := range c.Rules {
+			if r == rule {
+				if command[0] == "-D" {
+					c.Rules = append(c.Rules[:i], c.Rules[i+1:]...)
+				}
+				return 0, nil
+			}
+		}
+		return 1, errors.New(badRule)
+	case "-I":
+		// The position is always 1
+		rule = strings.Join(command[3:], " ")
+		c.Rules = append([]string{rule}, c.Rules...)
+	case "-F":
+		c.Rules = nil
+	case "-X":
+		if len(c.Rules) > 0 {
+			return 1, errors.New("iptables v1.8.4 (legacy): delete chain: Directory not empty")
+		}
+		for _, other := range table.Chains {
+			for _, r := range other.Rules {
+				if r == "-j "+c.Name {
+					return 1, errors.New("iptables v1.8.4 (legacy): delete chain: Too many links")
+				}
+			}
+		}
+		for i, other := range table.Chains {
+			if other == c {
+				table.Chains = append(table.Chains[:i], table.Chains[i+1:]...)
+				break
+			}
+		}
+	default:
+		return 2, fmt.Errorf("iptables %s: not faked", strings.Join(args, " "))
+	}
+	return 0, nil
+}
@@ -0,0 +1,114 @@
+// Package reconciler keeps the iptables-manager chain in the desired state.
+package reconciler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/rules"
+)
+
+// Source provides rules of the chain.
+type Source interface {
+	Rules() ([]rules.Rule, error)
+}
+
+// Static is a fixed list of rules.
+type Static []rules.Rule
+
+// Rules returns the fixed rules.
+func (s Static) Rules() ([]rules.Rule, error) {
+	return s, nil
+}
+
+// Reconciler replaces the chain with the rules of its sources, in order,
+// whenever the chain drifted from them.
+type Reconciler struct {
+	Chain    string
+	From     string
+	Sources  []Source
+	Executor *iptables.Executor
+	// Trigger requests a reconcile before the next interval.
+	Trigger chan struct{}
+}
+
+// New returns a reconciler of chain, jumped to from the built-in chain from.
+func New(chain, from string, executor *iptables.Executor, sources ...Source) *Reconciler {
+	return &Reconciler{
+		Chain:    chain,
+		From:     from,
+		Sources:  sources,
+		Executor: executor,
+		Trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Run reconciles every interval and on each trigger until ctx is done.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Reconcile(); err != nil {
+			log.Printf("reconciling chain %s failed: %v", r.Chain, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.Trigger:
+		}
+	}
+}
+
+// Reconcile applies the desired rules if the chain or the jump to it
+// drifted. A source failing leaves the chain as is.
+func (r *Reconciler) Reconcile() error {
+	var desired []rules.Rule
+	for _, source := range r.Sources {
+		sourceRules, err := source.Rules()
+		if err != nil {
+			return err
+		}
+		desired = append(desired, sourceRules...)
+	}
+
+	current, exists, err := r.Executor.ChainRules(r.Chain)
+	if err != nil {
+		return err
+	}
+	if !exists || !equal(current, desired, r.Chain) {
+		if exists {
+			log.Printf("chain %s drifted, replacing its %d rules with %d rules", r.Chain, len(current), len(desired))
+		} else {
+			log.Printf("creating chain %s with %d rules", r.Chain, len(desired))
+		}
+		if err := r.Executor.Restore(rules.Restore(r.Chain, desired)); err != nil {
+			return err
+		}
+	}
+	if !r.Executor.HasJump(r.From, r.Chain) {
+		log.Printf("inserting jump from %s to %s", r.From, r.Chain)
+		return r.Executor.InsertJump(r.From, r.Chain)
+	}
+	return nil
+}
+
+// Cleanup removes the jump to the chain and the chain itself.
+func (r *Reconciler) Cleanup() error {
+	log.Printf("removing chain %s", r.Chain)
+	return r.Executor.DeleteChain(r.From, r.Chain)
+}
+
+func equal(current []string, desired []rules.Rule, chain string) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for i, rule := range desired {
+		if current[i] != rule.Render(chain) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/iptables/iptablestest"
+	"github.com/cloudfoundry-incubator/iptables-manager/pkg/rules"
+)
+
+func TestMain(m *testing.M) {
+	iptablestest.Main(m)
+}
+
+const chain = "SF-TEST"
+
+var desired = Static{
+	{Source: "10.0.0.0/24", Protocol: "tcp", DestPort: "22", Target: rules.Accept},
+	{Source: "10.0.1.0/24", Protocol: "icmp", ICMPType: "8", Target: rules.Accept},
+	{Protocol: "udp", SourcePort: "53", Target: rules.Accept},
+	{Protocol: "tcp", Target: rules.Drop},
+}
+
+type failing struct{}
+
+func (failing) Rules() ([]rules.Rule, error) {
+	return nil, errors.New("apiserver down")
+}
+
+func chainOf(t *testing.T, fake *iptablestest.Fake, name string) *iptablestest.Chain {
+	for _, c := range fake.Table().Chains {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestReconcileCreatesChainAndJump(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	r := New(chain, "INPUT", fake.Executor(), desired)
+
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	c := chainOf(t, fake, chain)
+	if c == nil || len(c.Rules) != len(desired) {
+		t.Fatalf("chain after the first reconcile: %+v", c)
+	}
+	if input := chainOf(t, fake, "INPUT"); len(input.Rules) != 1 || input.Rules[0] != "-j "+chain {
+		t.Fatalf("INPUT after the first reconcile: %v", input.Rules)
+	}
+
+	// In sync, nothing is applied again
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if table := fake.Table(); table.Restores != 1 {
+		t.Errorf("%d restores of a chain in sync", table.Restores)
+	}
+	if input := chainOf(t, fake, "INPUT"); len(input.Rules) != 1 {
+		t.Errorf("jump inserted again: %v", input.Rules)
+	}
+}
+
+func TestReconcileRepairsDrift(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	r := New(chain, "INPUT", fake.Executor(), desired)
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone appends a rule to the chain and removes the jump
+	table := fake.Table()
+	for _, c := range table.Chains {
+		switch c.Name {
+		case chain:
+			c.Rules = append(c.Rules, "-s 192.168.0.1/32 -j ACCEPT")
+		case "INPUT":
+			c.Rules = nil
+		}
+	}
+	fake.Write(table)
+
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	c := chainOf(t, fake, chain)
+	for i, rule := range desired {
+		if i >= len(c.Rules) || "-A "+chain+" "+c.Rules[i] != rule.Render(chain) {
+			t.Fatalf("chain after repairing the drift: %v", c.Rules)
+		}
+	}
+	if len(c.Rules) != len(desired) {
+		t.Fatalf("appended rule kept: %v", c.Rules)
+	}
+	if input := chainOf(t, fake, "INPUT"); len(input.Rules) != 1 {
+		t.Fatalf("jump not inserted again: %v", input.Rules)
+	}
+}
+
+func TestReconcileKeepsChainOnSourceError(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	e := fake.Executor()
+	if err := New(chain, "INPUT", e, desired).Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(chain, "INPUT", e, desired, failing{}).Reconcile(); err == nil {
+		t.Fatal("source error not returned")
+	}
+	if c := chainOf(t, fake, chain); len(c.Rules) != len(desired) {
+		t.Fatalf("chain changed after a source error: %v", c.Rules)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	fake := iptablestest.New(t)
+	defer fake.Close()
+	r := New(chain, "INPUT", fake.Executor(), desired)
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if c := chainOf(t, fake, chain); c != nil {
+		t.Errorf("chain left after cleanup: %+v", c)
+	}
+	if input := chainOf(t, fake, "INPUT"); len(input.Rules) != 0 {
+		t.Errorf("jump left after cleanup: %v", input.Rules)
+	}
+	// The chain is gone, e.g. after a restart of a stopping manager
+	if err := r.Cleanup(); err != nil {
+		t.Errorf("cleanup of a removed chain: %v", err)
+	}
+}
+
+// TestReconcileInNetworkNamespace runs the reconciler against the real
+// iptables tools in a throwaway network namespace. It checks that the
+// rendered rules match what iptables-save prints, as otherwise every
+// reconcile would see drift.
+func TestReconcileInNetworkNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create a network namespace")
+	}
+	for _, tool := range []string{"ip", "iptables", "iptables-save", "iptables-restore"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	netns := fmt.Sprintf("iptables-manager-test-%d", os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", netns).CombinedOutput(); err != nil {
+		t.Skipf("creating network namespace: %v: %s", err, out)
+	}
+	defer exec.Command("ip", "netns", "delete", netns).Run()
+
+	e := &iptables.Executor{Command: []string{"ip", "netns", "exec", netns}, Wait: true}
+	r := New(chain, "INPUT", e, desired)
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	current, exists, err := e.ChainRules(chain)
+	if err != nil || !exists {
+		t.Fatalf("chain after reconcile: exists %t, %v", exists, err)
+	}
+	if !equal(current, desired, chain) {
+		t.Fatalf("iptables-save prints\n%q\nfor the rendered rules", current)
+	}
+	if !e.HasJump("INPUT", chain) {
+		t.Fatal("jump missing after reconcile")
+	}
+
+	if err := r.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, _ := e.ChainRules(chain); exists {
+		t.Fatal("chain left after cleanup")
+	}
+}
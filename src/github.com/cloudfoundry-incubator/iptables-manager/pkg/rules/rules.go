@@ -0,0 +1,173 @@
+// Package rules describes the firewall rules of the iptables-manager chain
+// and renders them in iptables-save syntax.
+package rules
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Targets of a rule.
+const (
+	Accept = "ACCEPT"
+	Drop   = "DROP"
+	// Return leaves the chain, e.g. to let Docker's own FORWARD rules
+	// decide on traffic from DOCKER-USER.
+	Return = "RETURN"
+)
+
+// Rule matches packets by source, protocol and ports and jumps to Target.
+type Rule struct {
+	// Source is a CIDR, empty matches any source.
+	Source   string
+	Protocol string
+	// SourcePort and DestPort are a port or a range "from:to".
+	SourcePort string
+	DestPort   string
+	// ICMPType is only used with the icmp protocol.
+	ICMPType string
+	// OrigDest and OrigDestPort match the destination of a connection
+	// before DNAT, i.e. the host address and port Docker published a
+	// container port on. Only packets in the original direction of the
+	// connection are matched, so replies are left alone.
+	OrigDest     string
+	OrigDestPort string
+	Target       string
+}
+
+// Render returns the rule as appended to chain in iptables-save syntax, so
+// that it can be compared with the output of iptables-save.
+func (r Rule) Render(chain string) string {
+	parts := []string{"-A", chain}
+	if r.Source != "" {
+		parts = append(parts, "-s", r.Source)
+	}
+	if r.Protocol != "" {
+		parts = append(parts, "-p", r.Protocol)
+		// iptables-save only lists the protocol match if it is used
+		if r.SourcePort != "" || r.DestPort != "" || r.ICMPType != "" {
+			parts = append(parts, "-m", r.Protocol)
+		}
+		if r.SourcePort != "" {
+			parts = append(parts, "--sport", r.SourcePort)
+		}
+		if r.DestPort != "" {
+			parts = append(parts, "--dport", r.DestPort)
+		}
+		if r.ICMPType != "" {
+			parts = append(parts, "--icmp-type", r.ICMPType)
+		}
+	}
+	if r.OrigDest != "" || r.OrigDestPort != "" {
+		// In the order iptables-save prints the conntrack options
+		parts = append(parts, "-m", "conntrack")
+		if r.OrigDest != "" {
+			parts = append(parts, "--ctorigdst", r.OrigDest)
+		}
+		if r.OrigDestPort != "" {
+			parts = append(parts, "--ctorigdstport", r.OrigDestPort)
+		}
+		parts = append(parts, "--ctdir", "ORIGINAL")
+	}
+	parts = append(parts, "-j", r.Target)
+	return strings.Join(parts, " ")
+}
+
+// Entry is an element of the allow or block list: a source address or
+// CIDR, optionally limited to a destination port or port range.
+type Entry struct {
+	Source string
+	Port   string
+}
+
+// ParseList parses a comma or space separated list of entries of the form
+// <ip>[/<prefix>][:<port>[-<port>]].
+func ParseList(list string) ([]Entry, error) {
+	var entries []Entry
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		entry, err := ParseEntry(field)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ParseEntry parses a single allow or block list entry.
+func ParseEntry(s string) (Entry, error) {
+	address, port := s, ""
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		address, port = s[:i], s[i+1:]
+	}
+	source, err := NormalizeSource(address)
+	if err != nil {
+		return Entry{}, err
+	}
+	if port != "" {
+		if port, err = normalizePort(port); err != nil {
+			return Entry{}, fmt.Errorf("%s: %v", s, err)
+		}
+	}
+	return Entry{Source: source, Port: port}, nil
+}
+
+// NormalizeSource returns the address or CIDR in the form iptables-save
+// prints it: the network address with its prefix length.
+func NormalizeSource(address string) (string, error) {
+	if !strings.Contains(address, "/") {
+		address += "/32"
+	}
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return "", err
+	}
+	if network.IP.To4() == nil {
+		return "", fmt.Errorf("%s: only IPv4 is supported", address)
+	}
+	return network.String(), nil
+}
+
+func normalizePort(port string) (string, error) {
+	bounds := strings.SplitN(port, "-", 2)
+	for _, bound := range bounds {
+		n, err := strconv.Atoi(bound)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port %q", bound)
+		}
+	}
+	return strings.Join(bounds, ":"), nil
+}
+
+// ForEntry returns the rules jumping to target for traffic from the entry:
+// ICMP echo requests and all TCP and UDP traffic, or TCP and UDP traffic to
+// the port of the entry.
+func ForEntry(entry Entry, target string) []Rule {
+	if entry.Port != "" {
+		return []Rule{
+			{Source: entry.Source, Protocol: "tcp", DestPort: entry.Port, Target: target},
+			{Source: entry.Source, Protocol: "udp", DestPort: entry.Port, Target: target},
+		}
+	}
+	return []Rule{
+		{Source: entry.Source, Protocol: "icmp", ICMPType: "8", Target: target},
+		{Source: entry.Source, Protocol: "udp", Target: target},
+		{Source: entry.Source, Protocol: "tcp", Target: target},
+	}
+}
+
+// Restore renders the input of iptables-restore --noflush which replaces
+// the content of chain with rules, leaving all other chains untouched.
+func Restore(chain string, rules []Rule) string {
+	var b strings.Builder
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", chain)
+	for _, rule := range rules {
+		b.WriteString(rule.Render(chain))
+		b.WriteString("\n")
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
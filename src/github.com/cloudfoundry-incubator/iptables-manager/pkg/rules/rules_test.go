@@ -0,0 +1,66 @@
+package rules
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	for _, tc := range []struct {
+		rule Rule
+		want string
+	}{
+		{
+			Rule{Protocol: "udp", SourcePort: "53", Target: Accept},
+			"-A C -p udp -m udp --sport 53 -j ACCEPT",
+		},
+		{
+			Rule{Source: "10.0.0.0/24", Protocol: "tcp", Target: Drop},
+			"-A C -s 10.0.0.0/24 -p tcp -j DROP",
+		},
+		{
+			Rule{Source: "10.0.0.0/24", Protocol: "icmp", ICMPType: "8", Target: Accept},
+			"-A C -s 10.0.0.0/24 -p icmp -m icmp --icmp-type 8 -j ACCEPT",
+		},
+		{
+			Rule{Source: "10.0.0.0/24", Protocol: "tcp", OrigDest: "10.1.0.5/32", OrigDestPort: "32768", Target: Return},
+			"-A C -s 10.0.0.0/24 -p tcp -m conntrack --ctorigdst 10.1.0.5/32 --ctorigdstport 32768 --ctdir ORIGINAL -j RETURN",
+		},
+	} {
+		if got := tc.rule.Render("C"); got != tc.want {
+			t.Errorf("got  %s\nwant %s", got, tc.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	entries, err := ParseList("127.0.0.1, 10.0.0.7/24:8080-8090 192.168.1.1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{"127.0.0.1/32", ""}, {"10.0.0.0/24", "8080:8090"}, {"192.168.1.1/32", "22"}}
+	if len(entries) != len(want) {
+		t.Fatalf("got %v, want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d: got %v, want %v", i, entries[i], want[i])
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.1:0", "10.0.0.1:80-x", "::1", "host"} {
+		if _, err := ParseList(invalid); err == nil {
+			t.Errorf("%q accepted", invalid)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	got := Restore("C", ForEntry(Entry{Source: "10.0.0.0/24", Port: "22"}, Drop))
+	want := `*filter
+:C - [0:0]
+-A C -s 10.0.0.0/24 -p tcp -m tcp --dport 22 -j DROP
+-A C -s 10.0.0.0/24 -p udp -m udp --dport 22 -j DROP
+COMMIT
+`
+	if got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+// lvmvd-exporter serves Prometheus metrics of the lvmvd volume driver. It
+// sits on the volume plugin socket Docker connects to and forwards every
+// call to lvmvd, so it sees the latency and outcome of each call without
+// changes to lvmvd. Volume group and volume usage are read from lvm on
+// every scrape.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/config"
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/lvm"
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/metrics"
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/plugin"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/docker/config/lvmvd-exporter.json", "path to the configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading configuration: %v", err)
+	}
+
+	proxy := plugin.NewProxy(cfg.BackendSocket)
+	collector := lvm.NewCollector(cfg.VolumeGroup)
+
+	// A socket left over by a killed exporter would make Listen fail
+	os.Remove(cfg.PluginSocket)
+	pluginListener, err := net.Listen("unix", cfg.PluginSocket)
+	if err != nil {
+		log.Fatalf("listening on %s: %v", cfg.PluginSocket, err)
+	}
+	stopping := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopping)
+		// Closing the listener removes the socket, so Docker does not
+		// find a stale plugin
+		pluginListener.Close()
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		mw := metrics.NewWriter(w)
+		collector.WriteMetrics(mw)
+		proxy.WriteMetrics(mw)
+		if err := mw.Flush(); err != nil {
+			log.Printf("writing metrics: %v", err)
+		}
+	})
+	go func() {
+		log.Printf("serving metrics on %s", cfg.Listen)
+		if err := http.ListenAndServe(cfg.Listen, mux); err != nil {
+			log.Fatalf("serving metrics on %s: %v", cfg.Listen, err)
+		}
+	}()
+
+	log.Printf("forwarding %s to %s", cfg.PluginSocket, cfg.BackendSocket)
+	if err := http.Serve(pluginListener, proxy); err != nil {
+		select {
+		case <-stopping:
+		default:
+			log.Fatalf("serving %s: %v", cfg.PluginSocket, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package config loads the lvmvd-exporter configuration rendered by the
+// docker BOSH job.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config is the lvmvd-exporter configuration.
+type Config struct {
+	// Listen is the host:port serving /metrics.
+	Listen string `json:"listen"`
+	// PluginSocket is the volume plugin socket Docker connects to. The
+	// exporter listens on it and forwards every call to BackendSocket,
+	// where lvmvd listens, measuring latency and errors on the way.
+	PluginSocket  string `json:"plugin_socket"`
+	BackendSocket string `json:"backend_socket"`
+	// VolumeGroup is the volume group lvmvd creates its volumes in.
+	VolumeGroup string `json:"volume_group"`
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Listen: "127.0.0.1:9188"}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if cfg.PluginSocket == "" || cfg.BackendSocket == "" {
+		return nil, fmt.Errorf("plugin_socket and backend_socket are required")
+	}
+	if cfg.PluginSocket == cfg.BackendSocket {
+		return nil, fmt.Errorf("plugin_socket and backend_socket must differ")
+	}
+	if cfg.VolumeGroup == "" {
+		return nil, fmt.Errorf("volume_group is required")
+	}
+	return cfg, nil
+}
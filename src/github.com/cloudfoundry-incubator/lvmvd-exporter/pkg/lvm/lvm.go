@@ -0,0 +1,178 @@
+// Package lvm reads the size and usage of the lvmvd volume group and its
+// volumes from lvm, /proc/mounts and the mounted filesystems.
+package lvm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/metrics"
+)
+
+// Runner runs an lvm command and returns its standard output.
+type Runner func(name string, args ...string) ([]byte, error)
+
+// Exec runs the command with os/exec.
+func Exec(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// Filesystem is the size and usage of a mounted filesystem.
+type Filesystem struct {
+	Size, Used uint64
+}
+
+// Statfs returns the filesystem mounted at path.
+func Statfs(path string) (Filesystem, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Filesystem{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return Filesystem{Size: st.Blocks * bsize, Used: (st.Blocks - st.Bfree) * bsize}, nil
+}
+
+// Collector collects the metrics of one volume group.
+type Collector struct {
+	VolumeGroup string
+	Run         Runner
+	Mounts      string
+	Statfs      func(path string) (Filesystem, error)
+}
+
+// NewCollector returns the collector of the volume group vg.
+func NewCollector(vg string) *Collector {
+	return &Collector{VolumeGroup: vg, Run: Exec, Mounts: "/proc/mounts", Statfs: Statfs}
+}
+
+type volume struct {
+	name       string
+	size       uint64
+	mounts     []string
+	filesystem *Filesystem
+}
+
+// WriteMetrics writes the volume group and volume metrics. lvmvd_up is 0
+// if lvm could not be queried, the other samples are left out then.
+func (c *Collector) WriteMetrics(w *metrics.Writer) {
+	size, free, volumes, err := c.collect()
+	w.Header("lvmvd_lvm_up", "gauge", "Whether the volume group could be read from lvm.")
+	if err != nil {
+		w.Sample("lvmvd_lvm_up", 0)
+		return
+	}
+	w.Sample("lvmvd_lvm_up", 1)
+
+	vg := []string{"volume_group", c.VolumeGroup}
+	w.Header("lvmvd_volume_group_size_bytes", "gauge", "Size of the volume group.")
+	w.Sample("lvmvd_volume_group_size_bytes", float64(size), vg...)
+	w.Header("lvmvd_volume_group_free_bytes", "gauge", "Space of the volume group not allocated to volumes.")
+	w.Sample("lvmvd_volume_group_free_bytes", float64(free), vg...)
+	w.Header("lvmvd_volume_group_used_bytes", "gauge", "Space of the volume group allocated to volumes.")
+	w.Sample("lvmvd_volume_group_used_bytes", float64(size-free), vg...)
+
+	w.Header("lvmvd_volume_size_bytes", "gauge", "Size of the logical volume of a Docker volume.")
+	for _, v := range volumes {
+		w.Sample("lvmvd_volume_size_bytes", float64(v.size), "volume", v.name)
+	}
+	w.Header("lvmvd_volume_mounts", "gauge", "Mount points of a Docker volume on the host.")
+	for _, v := range volumes {
+		w.Sample("lvmvd_volume_mounts", float64(len(v.mounts)), "volume", v.name)
+	}
+	w.Header("lvmvd_volume_filesystem_size_bytes", "gauge", "Size of the filesystem of a mounted Docker volume.")
+	for _, v := range volumes {
+		if v.filesystem != nil {
+			w.Sample("lvmvd_volume_filesystem_size_bytes", float64(v.filesystem.Size), "volume", v.name)
+		}
+	}
+	w.Header("lvmvd_volume_filesystem_used_bytes", "gauge", "Used space of the filesystem of a mounted Docker volume.")
+	for _, v := range volumes {
+		if v.filesystem != nil {
+			w.Sample("lvmvd_volume_filesystem_used_bytes", float64(v.filesystem.Used), "volume", v.name)
+		}
+	}
+}
+
+func (c *Collector) collect() (size, free uint64, volumes []volume, err error) {
+	out, err := c.Run("vgs", "--noheadings", "--nosuffix", "--units", "b", "--separator", ",", "-o", "vg_size,vg_free", c.VolumeGroup)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("vgs %s: %v", c.VolumeGroup, err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(fields) != 2 {
+		return 0, 0, nil, fmt.Errorf("unexpected vgs output %q", out)
+	}
+	if size, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return 0, 0, nil, err
+	}
+	if free, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return 0, 0, nil, err
+	}
+
+	out, err = c.Run("lvs", "--noheadings", "--nosuffix", "--units", "b", "--separator", ",", "-o", "lv_name,lv_size,origin", c.VolumeGroup)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("lvs %s: %v", c.VolumeGroup, err)
+	}
+	mounts, err := c.mounts()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		// Snapshots (bin/lvmvd_snapshot) have an origin and are no volumes
+		if len(fields) != 3 || fields[2] != "" {
+			continue
+		}
+		v := volume{name: fields[0]}
+		if v.size, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return 0, 0, nil, err
+		}
+		v.mounts = mounts[c.device(v.name)]
+		if len(v.mounts) > 0 {
+			if fs, err := c.Statfs(v.mounts[0]); err == nil {
+				v.filesystem = &fs
+			}
+		}
+		volumes = append(volumes, v)
+	}
+	return size, free, volumes, nil
+}
+
+// device is the device mapper path a logical volume is mounted from.
+func (c *Collector) device(lv string) string {
+	return "/dev/mapper/" + strings.Replace(c.VolumeGroup, "-", "--", -1) + "-" + strings.Replace(lv, "-", "--", -1)
+}
+
+// mounts maps the mounted devices to their mount points.
+func (c *Collector) mounts() (map[string][]string, error) {
+	f, err := os.Open(c.Mounts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mounts := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		device := fields[0]
+		// /dev/<vg>/<lv> is a symlink to the device mapper path
+		if parts := strings.Split(device, "/"); len(parts) == 4 && parts[1] == "dev" && parts[2] == c.VolumeGroup {
+			device = c.device(parts[3])
+		}
+		mounts[device] = append(mounts[device], unescape(fields[1]))
+	}
+	return mounts, scanner.Err()
+}
+
+// unescape decodes the octal escapes of spaces and tabs in /proc/mounts.
+func unescape(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
@@ -0,0 +1,96 @@
+package lvm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/metrics"
+)
+
+func collector(t *testing.T, mounts string, run Runner) (*Collector, func()) {
+	f, err := ioutil.TempFile("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(mounts)
+	f.Close()
+	return &Collector{
+		VolumeGroup: "service-vg",
+		Run:         run,
+		Mounts:      f.Name(),
+		Statfs: func(path string) (Filesystem, error) {
+			if path != "/var/vcap/store/lvmvd/mountpoints/data-1" {
+				return Filesystem{}, fmt.Errorf("unexpected statfs of %s", path)
+			}
+			return Filesystem{Size: 1000, Used: 250}, nil
+		},
+	}, func() { os.Remove(f.Name()) }
+}
+
+func lvmOutput(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "vgs":
+		return []byte("  65494056960,63346573312\n"), nil
+	case "lvs":
+		return []byte("  data-1,1073741824,\n  cache,1073741824,\n  data-1-20260101,214748364,data-1\n"), nil
+	}
+	return nil, fmt.Errorf("unexpected command %s", name)
+}
+
+func write(c *Collector) string {
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	c.WriteMetrics(w)
+	w.Flush()
+	return buf.String()
+}
+
+func TestCollector(t *testing.T) {
+	c, cleanup := collector(t, "/dev/sda1 / ext4 rw 0 0\n"+
+		"/dev/mapper/service--vg-data--1 /var/vcap/store/lvmvd/mountpoints/data-1 ext4 rw 0 0\n", lvmOutput)
+	defer cleanup()
+	out := write(c)
+	for _, want := range []string{
+		`lvmvd_lvm_up 1`,
+		`lvmvd_volume_group_size_bytes{volume_group="service-vg"} 65494056960`,
+		`lvmvd_volume_group_free_bytes{volume_group="service-vg"} 63346573312`,
+		`lvmvd_volume_group_used_bytes{volume_group="service-vg"} 2147483648`,
+		`lvmvd_volume_size_bytes{volume="data-1"} 1073741824`,
+		`lvmvd_volume_mounts{volume="data-1"} 1`,
+		`lvmvd_volume_mounts{volume="cache"} 0`,
+		`lvmvd_volume_filesystem_used_bytes{volume="data-1"} 250`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics miss %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "data-1-20260101") {
+		t.Errorf("snapshot reported as volume:\n%s", out)
+	}
+	if strings.Contains(out, `lvmvd_volume_filesystem_size_bytes{volume="cache"}`) {
+		t.Errorf("filesystem of unmounted volume reported:\n%s", out)
+	}
+}
+
+func TestCollectorVolumeGroupPath(t *testing.T) {
+	c, cleanup := collector(t, "/dev/service-vg/data-1 /var/vcap/store/lvmvd/mountpoints/data-1 ext4 rw 0 0\n", lvmOutput)
+	defer cleanup()
+	if out := write(c); !strings.Contains(out, `lvmvd_volume_mounts{volume="data-1"} 1`+"\n") {
+		t.Errorf("mount through /dev/<vg>/<lv> not counted:\n%s", out)
+	}
+}
+
+func TestCollectorLvmDown(t *testing.T) {
+	c, cleanup := collector(t, "", func(name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("exit status 5")
+	})
+	defer cleanup()
+	out := write(c)
+	if !strings.Contains(out, "lvmvd_lvm_up 0\n") || strings.Contains(out, "lvmvd_volume_group_size_bytes") {
+		t.Errorf("unexpected metrics without lvm:\n%s", out)
+	}
+}
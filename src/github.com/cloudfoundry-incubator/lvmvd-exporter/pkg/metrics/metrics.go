@@ -0,0 +1,94 @@
+// Package metrics writes metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms.
+// Mount and Remove of large volumes can take a while, hence the long tail.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observations in cumulative buckets. It is not safe for
+// concurrent use, its owner has to lock it.
+type Histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram returns a histogram with the given ascending bucket bounds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// Observe adds v to the histogram.
+func (h *Histogram) Observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Writer writes the metric families one after the other. Errors are kept
+// and returned by Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a writer to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Header starts a metric family of the given type (gauge, counter or
+// histogram).
+func (w *Writer) Header(name, typ, help string) {
+	w.w.WriteString("# HELP " + name + " " + help + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes one sample. labels are name and value pairs.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	w.labels(labels)
+	w.w.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+// Histogram writes the bucket, sum and count samples of h.
+func (w *Writer) Histogram(name string, h *Histogram, labels ...string) {
+	for i, bound := range h.bounds {
+		w.Sample(name+"_bucket", float64(h.counts[i]), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	w.Sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	w.Sample(name+"_sum", h.sum, labels...)
+	w.Sample(name+"_count", float64(h.count), labels...)
+}
+
+// Flush writes the buffered samples.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (w *Writer) labels(labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		w.w.WriteString(labels[i] + `="` + escaper.Replace(labels[i+1]) + `"`)
+	}
+	w.w.WriteByte('}')
+}
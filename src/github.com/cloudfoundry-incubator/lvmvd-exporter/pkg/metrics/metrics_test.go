@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header("lvmvd_test", "gauge", "Test gauge.")
+	w.Sample("lvmvd_test", 1.5, "volume", `a"b\c`)
+	w.Sample("lvmvd_test", 2)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "# HELP lvmvd_test Test gauge.\n" +
+		"# TYPE lvmvd_test gauge\n" +
+		`lvmvd_test{volume="a\"b\\c"} 1.5` + "\n" +
+		"lvmvd_test 2\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Histogram("lvmvd_latency", h, "call", "Mount")
+	w.Flush()
+	want := `lvmvd_latency_bucket{call="Mount",le="0.1"} 1` + "\n" +
+		`lvmvd_latency_bucket{call="Mount",le="1"} 2` + "\n" +
+		`lvmvd_latency_bucket{call="Mount",le="+Inf"} 3` + "\n" +
+		`lvmvd_latency_sum{call="Mount"} 5.55` + "\n" +
+		`lvmvd_latency_count{call="Mount"} 3` + "\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,149 @@
+// Package plugin forwards the Docker volume plugin API from the socket Docker
+// connects to on to lvmvd, counting the calls, their errors and latencies.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/metrics"
+)
+
+// Proxy is the http.Handler forwarding to lvmvd.
+type Proxy struct {
+	proxy *httputil.ReverseProxy
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	requests uint64
+	errors   uint64
+	latency  *metrics.Histogram
+}
+
+type outcomeKey struct{}
+
+// outcome is set by the response hooks of the reverse proxy and read once
+// the request is done.
+type outcome struct {
+	failed bool
+}
+
+// NewProxy returns the proxy to the lvmvd socket at backendSocket.
+func NewProxy(backendSocket string) *Proxy {
+	p := &Proxy{calls: map[string]*call{}}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", backendSocket)
+		},
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "lvmvd"
+		},
+		Transport:      transport,
+		ModifyResponse: checkResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			r.Context().Value(outcomeKey{}).(*outcome).failed = true
+			log.Printf("forwarding %s to lvmvd: %v", r.URL.Path, err)
+			// Docker shows the Err of the plugin response to the user
+			w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(struct{ Err string }{fmt.Sprintf("lvmvd unavailable: %v", err)})
+		},
+	}
+	return p
+}
+
+// ServeHTTP forwards the call and records its outcome.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o := &outcome{}
+	start := time.Now()
+	p.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), outcomeKey{}, o)))
+	p.record(callName(r.URL.Path), time.Since(start), o.failed)
+}
+
+// checkResponse marks the call failed if lvmvd answered with an error
+// status or a non-empty Err, which is how plugins report failures.
+func checkResponse(resp *http.Response) error {
+	o := resp.Request.Context().Value(outcomeKey{}).(*outcome)
+	if resp.StatusCode != http.StatusOK {
+		o.failed = true
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var response struct{ Err string }
+	if json.Unmarshal(body, &response) == nil && response.Err != "" {
+		o.failed = true
+	}
+	return nil
+}
+
+// callName maps /VolumeDriver.Mount to Mount and /Plugin.Activate to
+// Activate.
+func callName(path string) string {
+	name := strings.TrimPrefix(path, "/")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (p *Proxy) record(name string, d time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.calls[name]
+	if !ok {
+		c = &call{latency: metrics.NewHistogram(metrics.DefaultBuckets)}
+		p.calls[name] = c
+	}
+	c.requests++
+	if failed {
+		c.errors++
+	}
+	c.latency.Observe(d.Seconds())
+}
+
+// WriteMetrics writes the call counters and latency histograms.
+func (p *Proxy) WriteMetrics(w *metrics.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.calls))
+	for name := range p.calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header("lvmvd_plugin_requests_total", "counter", "Docker volume plugin calls forwarded to lvmvd.")
+	for _, name := range names {
+		w.Sample("lvmvd_plugin_requests_total", float64(p.calls[name].requests), "call", name)
+	}
+	w.Header("lvmvd_plugin_errors_total", "counter", "Docker volume plugin calls lvmvd failed or answered with an error.")
+	for _, name := range names {
+		w.Sample("lvmvd_plugin_errors_total", float64(p.calls[name].errors), "call", name)
+	}
+	w.Header("lvmvd_plugin_request_duration_seconds", "histogram", "Latency of the Docker volume plugin calls.")
+	for _, name := range names {
+		w.Histogram("lvmvd_plugin_request_duration_seconds", p.calls[name].latency, "call", name)
+	}
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/lvmvd-exporter/pkg/metrics"
+)
+
+// fakeLvmvd serves the plugin API on a unix socket in a temporary directory.
+func fakeLvmvd(t *testing.T, handler http.HandlerFunc) (string, func()) {
+	dir, err := ioutil.TempDir("", "lvmvd-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "lvmvd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	return socket, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func send(t *testing.T, p *Proxy, path, body string) (int, string) {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec.Code, rec.Body.String()
+}
+
+func scrape(p *Proxy) string {
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	p.WriteMetrics(w)
+	w.Flush()
+	return buf.String()
+}
+
+func TestProxyForwardsAndCounts(t *testing.T) {
+	socket, cleanup := fakeLvmvd(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/VolumeDriver.Mount":
+			if string(body) != `{"Name":"data","ID":"c1"}` {
+				t.Errorf("lvmvd got body %s", body)
+			}
+			w.Write([]byte(`{"Mountpoint":"/mnt/data","Err":""}`))
+		case "/VolumeDriver.Remove":
+			w.Write([]byte(`{"Err":"volume data is in use"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer cleanup()
+	p := NewProxy(socket)
+
+	code, body := send(t, p, "/VolumeDriver.Mount", `{"Name":"data","ID":"c1"}`)
+	if code != http.StatusOK || body != `{"Mountpoint":"/mnt/data","Err":""}` {
+		t.Errorf("Mount answered %d %s", code, body)
+	}
+	code, body = send(t, p, "/VolumeDriver.Remove", `{"Name":"data"}`)
+	if code != http.StatusOK || !strings.Contains(body, "in use") {
+		t.Errorf("Remove answered %d %s", code, body)
+	}
+	send(t, p, "/VolumeDriver.Capabilities", `{}`)
+
+	out := scrape(p)
+	for _, want := range []string{
+		`lvmvd_plugin_requests_total{call="Mount"} 1`,
+		`lvmvd_plugin_errors_total{call="Mount"} 0`,
+		`lvmvd_plugin_errors_total{call="Remove"} 1`,
+		`lvmvd_plugin_errors_total{call="Capabilities"} 1`,
+		`lvmvd_plugin_request_duration_seconds_count{call="Remove"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics miss %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestProxyBackendDown(t *testing.T) {
+	p := NewProxy(filepath.Join(os.TempDir(), "lvmvd-exporter-missing.sock"))
+	code, body := send(t, p, "/VolumeDriver.Create", `{"Name":"data"}`)
+	if code != http.StatusBadGateway || !strings.Contains(body, `"Err":"lvmvd unavailable`) {
+		t.Errorf("Create answered %d %s", code, body)
+	}
+	if out := scrape(p); !strings.Contains(out, `lvmvd_plugin_errors_total{call="Create"} 1`) {
+		t.Errorf("error not counted:\n%s", out)
+	}
+}
+
+func TestCallName(t *testing.T) {
+	for path, want := range map[string]string{
+		"/VolumeDriver.Mount": "Mount",
+		"/Plugin.Activate":    "Activate",
+		"/unknown":            "unknown",
+	} {
+		if got := callName(path); got != want {
+			t.Errorf("callName(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// tls-agent terminates TLS in front of the Docker engine or the Swarm
+// manager. It watches the certificate files, optionally mirrored from a
+// Secret on the apiserver, and swaps the certificates for new connections
+// without restarting the engine or its containers. Certificates rotated in
+// the BOSH manifest restart the job anyway, the Secret is what makes
+// rotations restart free.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/certs"
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/config"
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/proxy"
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/secret"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/docker/config/tls-agent.json", "path to the configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading configuration: %v", err)
+	}
+
+	var syncer *secret.Syncer
+	if cfg.Secret != nil {
+		if syncer, err = secret.NewSyncer(*cfg.Secret, cfg.Cert, cfg.Key, cfg.CA); err != nil {
+			log.Fatalf("configuring secret %s/%s: %v", cfg.Secret.Namespace, cfg.Secret.Name, err)
+		}
+		// The rendered files are used if the apiserver is not reachable yet
+		if err := syncer.Sync(); err != nil {
+			log.Printf("syncing secret %s/%s: %v", cfg.Secret.Namespace, cfg.Secret.Name, err)
+		}
+	}
+
+	store, err := certs.NewStore(cfg.Cert, cfg.Key, cfg.CA, cfg.VerifyClients)
+	if err != nil {
+		log.Fatalf("loading certificates: %v", err)
+	}
+	warnExpiring(store, time.Duration(cfg.ExpiryWarning))
+
+	var backendTLS *tls.Config
+	if b := cfg.BackendTLS; b != nil {
+		if backendTLS, err = certs.ClientConfig(b.Cert, b.Key, b.CA, b.ServerName); err != nil {
+			log.Fatalf("loading backend certificates: %v", err)
+		}
+	}
+	p, err := proxy.New(cfg.Backend, backendTLS, store)
+	if err != nil {
+		log.Fatalf("configuring backend: %v", err)
+	}
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatalf("listening on %s: %v", cfg.Listen, err)
+	}
+	if cfg.StatusListen != "" {
+		go serveStatus(cfg.StatusListen, store)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		l.Close()
+	}()
+	go watch(ctx, cfg, syncer, store)
+
+	log.Printf("forwarding %s to %s", cfg.Listen, cfg.Backend)
+	if err := p.Serve(l); err != nil && ctx.Err() == nil {
+		log.Fatalf("serving %s: %v", cfg.Listen, err)
+	}
+}
+
+// watch syncs the secret and reloads the certificates every reload_interval.
+// A SIGHUP triggers an immediate reload.
+func watch(ctx context.Context, cfg *config.Config, syncer *secret.Syncer, store *certs.Store) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(time.Duration(cfg.ReloadInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-hup:
+		}
+		if syncer != nil {
+			if err := syncer.Sync(); err != nil {
+				log.Printf("syncing secret %s/%s: %v", cfg.Secret.Namespace, cfg.Secret.Name, err)
+			}
+		}
+		reloaded, err := store.Reload()
+		if err != nil {
+			log.Printf("reloading certificates, keeping the current ones: %v", err)
+			continue
+		}
+		if reloaded {
+			warnExpiring(store, time.Duration(cfg.ExpiryWarning))
+		}
+	}
+}
+
+func warnExpiring(store *certs.Store, within time.Duration) {
+	for _, e := range store.Expiries() {
+		if time.Until(e.NotAfter) < within {
+			log.Printf("WARNING: %s (%s) expires on %s", e.File, e.Subject, e.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+func serveStatus(addr string, store *certs.Store) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			LoadedAt     time.Time      `json:"loadedAt"`
+			Certificates []certs.Expiry `json:"certificates"`
+		}{store.LoadedAt(), store.Expiries()})
+	})
+	log.Printf("serving certificate status on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("serving status on %s: %v", addr, err)
+	}
+}
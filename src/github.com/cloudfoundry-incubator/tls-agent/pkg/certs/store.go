@@ -0,0 +1,191 @@
+// Package certs keeps the current TLS configuration built from certificate
+// files and swaps it whenever the files change.
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+// Expiry describes the validity of a loaded certificate.
+type Expiry struct {
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+}
+
+// Store holds the certificate, key and CA pool loaded last. New TLS
+// handshakes use them, established connections keep theirs.
+type Store struct {
+	certFile, keyFile, caFile string
+	verifyClients             bool
+
+	mu       sync.RWMutex
+	raw      []byte
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	expiries []Expiry
+	loadedAt time.Time
+}
+
+// NewStore loads the files, failing if they cannot be used.
+func NewStore(certFile, keyFile, caFile string, verifyClients bool) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile, caFile: caFile, verifyClients: verifyClients}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the files and swaps the TLS configuration if they changed.
+// Invalid files are reported and the previous configuration is kept.
+func (s *Store) Reload() (bool, error) {
+	certPEM, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := ioutil.ReadFile(s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	var caPEM []byte
+	if s.caFile != "" {
+		if caPEM, err = ioutil.ReadFile(s.caFile); err != nil {
+			return false, err
+		}
+	}
+	raw := bytes.Join([][]byte{certPEM, keyPEM, caPEM}, nil)
+
+	s.mu.RLock()
+	unchanged := bytes.Equal(raw, s.raw)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("loading %s and %s: %v", s.certFile, s.keyFile, err)
+	}
+	expiries, err := parseExpiries(s.certFile, certPEM)
+	if err != nil {
+		return false, err
+	}
+	var pool *x509.CertPool
+	if len(caPEM) > 0 {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("no certificates found in %s", s.caFile)
+		}
+		caExpiries, err := parseExpiries(s.caFile, caPEM)
+		if err != nil {
+			return false, err
+		}
+		expiries = append(expiries, caExpiries...)
+	}
+
+	s.mu.Lock()
+	s.raw, s.cert, s.pool, s.expiries, s.loadedAt = raw, &cert, pool, expiries, time.Now()
+	s.mu.Unlock()
+	for _, e := range expiries {
+		log.Printf("loaded %s (%s), valid until %s", e.File, e.Subject, e.NotAfter.Format(time.RFC3339))
+	}
+	return true, nil
+}
+
+// ServerConfig returns the TLS configuration of new incoming connections.
+func (s *Store) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.verifyClients {
+				cfg.ClientCAs = s.pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig loads the TLS configuration to dial a backend with the
+// given certificate, trusting only caFile. Unlike the Store, it does not
+// follow changes of the files.
+func ClientConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s and %s: %v", certFile, keyFile, err)
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+	}, nil
+}
+
+// Expiries returns the validity of the loaded certificates, recomputing the
+// days left.
+func (s *Store) Expiries() []Expiry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	expiries := make([]Expiry, len(s.expiries))
+	for i, e := range s.expiries {
+		e.DaysLeft = int(time.Until(e.NotAfter).Hours() / 24)
+		expiries[i] = e
+	}
+	return expiries
+}
+
+// LoadedAt returns the time the current configuration was loaded.
+func (s *Store) LoadedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadedAt
+}
+
+func parseExpiries(file string, data []byte) ([]Expiry, error) {
+	var expiries []Expiry
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", file, err)
+		}
+		expiries = append(expiries, Expiry{
+			File:     file,
+			Subject:  cert.Subject.String(),
+			NotAfter: cert.NotAfter,
+			DaysLeft: int(time.Until(cert.NotAfter).Hours() / 24),
+		})
+	}
+	return expiries, nil
+}
@@ -0,0 +1,127 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for cn, valid for validity,
+// and its key to dir/cert.pem and dir/key.pem.
+func writeCert(t *testing.T, dir, cn string, validity time.Duration) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func served(t *testing.T, s *Store) string {
+	cfg, err := s.ServerConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestReloadSwapsChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "old", 24*time.Hour)
+
+	s, err := NewStore(certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := s.Reload(); reloaded || err != nil {
+		t.Fatalf("unchanged files: reloaded %v, error %v", reloaded, err)
+	}
+
+	writeCert(t, dir, "new", 24*time.Hour)
+	if reloaded, err := s.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed files: reloaded %v, error %v", reloaded, err)
+	}
+	if cn := served(t, s); cn != "new" {
+		t.Fatalf("serving %s after the reload", cn)
+	}
+
+	// A broken key keeps the working configuration
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	if _, err := s.Reload(); err == nil {
+		t.Fatal("broken key accepted")
+	}
+	if cn := served(t, s); cn != "new" {
+		t.Fatalf("serving %s after a failed reload", cn)
+	}
+}
+
+func TestExpiries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "docker", 10*24*time.Hour+time.Hour)
+	ca := filepath.Join(dir, "ca.pem")
+	data, _ := ioutil.ReadFile(certFile)
+	ioutil.WriteFile(ca, data, 0600)
+
+	s, err := NewStore(certFile, keyFile, ca, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiries := s.Expiries()
+	if len(expiries) != 2 || expiries[0].File != certFile || expiries[1].File != ca {
+		t.Fatalf("got %+v, want the certificate and the CA", expiries)
+	}
+	if expiries[0].DaysLeft != 10 || expiries[0].Subject != "CN=docker" {
+		t.Fatalf("got %+v", expiries[0])
+	}
+	cfg, _ := s.ServerConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert || cfg.ClientCAs == nil {
+		t.Fatal("client certificates are not verified")
+	}
+}
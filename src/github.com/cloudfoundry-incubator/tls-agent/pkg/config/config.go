@@ -0,0 +1,115 @@
+// Package config loads the tls-agent configuration rendered by the docker
+// and swarm_manager BOSH jobs.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Config is the tls-agent configuration.
+type Config struct {
+	// Listen is the host:port TLS is terminated on.
+	Listen string `json:"listen"`
+	// Backend is the address connections are forwarded to, either
+	// unix:///path/to/socket or tcp://host:port.
+	Backend string `json:"backend"`
+	// BackendTLS, if set, dials the backend with TLS.
+	BackendTLS *BackendTLS `json:"backend_tls,omitempty"`
+
+	// Cert, Key and CA are the PEM files served and watched for changes.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+	// VerifyClients requires client certificates signed by CA.
+	VerifyClients bool `json:"verify_clients"`
+	// ReloadInterval is the time between two checks of the files.
+	ReloadInterval Duration `json:"reload_interval"`
+
+	// Secret, if set, is a kubernetes.io/tls Secret on the Service Fabrik
+	// apiserver which is written to Cert, Key and CA whenever it changes.
+	Secret *Secret `json:"secret,omitempty"`
+
+	// StatusListen serves the certificate expiry dates as JSON on /status.
+	StatusListen string `json:"status_listen,omitempty"`
+	// ExpiryWarning logs a warning for certificates expiring within it.
+	ExpiryWarning Duration `json:"expiry_warning"`
+}
+
+// BackendTLS are the PEM files the backend is dialed with. They are loaded
+// once at start and not watched: they are the files the backend itself was
+// started with, which a rotation of the served certificates must not
+// replace.
+type BackendTLS struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+	// ServerName is verified against the backend certificate. It defaults
+	// to the host of a tcp backend and is required for unix ones.
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// Secret locates the Secret holding tls.crt, tls.key and ca.crt.
+type Secret struct {
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	CA        string `json:"ca"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Duration is a time.Duration read from a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		ReloadInterval: Duration(30 * time.Second),
+		ExpiryWarning:  Duration(30 * 24 * time.Hour),
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if cfg.Listen == "" || cfg.Backend == "" {
+		return nil, fmt.Errorf("listen and backend are required")
+	}
+	if cfg.Cert == "" || cfg.Key == "" {
+		return nil, fmt.Errorf("cert and key are required")
+	}
+	if cfg.VerifyClients && cfg.CA == "" {
+		return nil, fmt.Errorf("verify_clients requires ca")
+	}
+	if b := cfg.BackendTLS; b != nil {
+		if b.Cert == "" || b.Key == "" || b.CA == "" {
+			return nil, fmt.Errorf("backend_tls requires cert, key and ca")
+		}
+		if b.ServerName == "" && strings.HasPrefix(cfg.Backend, "unix://") {
+			return nil, fmt.Errorf("backend_tls with a unix backend requires server_name")
+		}
+	}
+	if cfg.ReloadInterval <= 0 {
+		return nil, fmt.Errorf("reload_interval must be positive")
+	}
+	return cfg, nil
+}
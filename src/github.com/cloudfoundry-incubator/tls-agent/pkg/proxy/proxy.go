@@ -0,0 +1,119 @@
+// Package proxy terminates TLS and forwards the plain connections to the
+// backend, e.g. the unix socket of the Docker engine.
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/certs"
+)
+
+// Proxy forwards the connections accepted on a TLS listener to a backend.
+// Connections are copied byte by byte, so hijacked Docker API connections
+// (attach, exec) work as well.
+type Proxy struct {
+	network, address string
+	backendTLS       *tls.Config
+	store            *certs.Store
+}
+
+// New returns the proxy to backend (unix:///path or tcp://host:port). If
+// backendTLS is not nil the backend is dialed with it, verifying the host
+// of a tcp backend if backendTLS has no ServerName.
+func New(backend string, backendTLS *tls.Config, store *certs.Store) (*Proxy, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{backendTLS: backendTLS, store: store}
+	switch u.Scheme {
+	case "unix":
+		p.network, p.address = "unix", u.Path
+	case "tcp":
+		p.network, p.address = "tcp", u.Host
+		if backendTLS != nil && backendTLS.ServerName == "" {
+			p.backendTLS = backendTLS.Clone()
+			p.backendTLS.ServerName = u.Hostname()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported backend %q", backend)
+	}
+	return p, nil
+}
+
+// Serve accepts TLS connections on l until it is closed.
+func (p *Proxy) Serve(l net.Listener) error {
+	tlsListener := tls.NewListener(l, p.store.ServerConfig())
+	for {
+		conn, err := tlsListener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	backend, err := p.dial()
+	if err != nil {
+		log.Printf("connecting to backend %s failed: %v", p.address, err)
+		return
+	}
+	defer backend.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(backend, conn)
+		closeWrite(backend)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, backend)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+}
+
+func (p *Proxy) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(p.network, p.address, 10*time.Second)
+	if err != nil || p.backendTLS == nil {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, p.backendTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// closeWrite signals EOF to the peer while the other direction may still
+// be transferring.
+func closeWrite(conn net.Conn) {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		c.CloseWrite()
+	case *net.TCPConn:
+		c.CloseWrite()
+	case *net.UnixConn:
+		c.CloseWrite()
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/certs"
+)
+
+// writeCert writes a self-signed certificate for cn, which doubles as CA,
+// and its key to dir.
+func writeCert(t *testing.T, dir, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"docker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// echo serves a byte echo on a unix socket, optionally with TLS.
+func echo(t *testing.T, socket string, tlsConfig *tls.Config) net.Listener {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+type fixture struct {
+	dir   string
+	store *certs.Store
+	addr  string
+	close func()
+}
+
+// start runs the proxy in front of an echo backend. With backendTLS the
+// backend serves a certificate of its own and requires it as client
+// certificate, like the Swarm manager started with the rendered files.
+func start(t *testing.T, backendTLS bool) *fixture {
+	dir, err := ioutil.TempDir("", "tls-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{dir: dir}
+	certFile, keyFile := writeCert(t, dir, "first")
+	socket := filepath.Join(dir, "docker.sock")
+
+	var backendConfig, clientConfig *tls.Config
+	if backendTLS {
+		backendDir := filepath.Join(dir, "backend")
+		if err := os.Mkdir(backendDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		backendCert, backendKey := writeCert(t, backendDir, "backend")
+		cert, err := tls.LoadX509KeyPair(backendCert, backendKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(leaf)
+		backendConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		// The self-signed certificate doubles as CA
+		if clientConfig, err = certs.ClientConfig(backendCert, backendKey, backendCert, "docker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := echo(t, socket, backendConfig)
+
+	if f.store, err = certs.NewStore(certFile, keyFile, "", false); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New("unix://"+socket, clientConfig, f.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	f.addr = l.Addr().String()
+	f.close = func() {
+		l.Close()
+		backend.Close()
+		os.RemoveAll(dir)
+	}
+	return f
+}
+
+// connect opens a connection through the proxy, checks the echo and
+// returns the common name of the served certificate.
+func (f *fixture) connect(t *testing.T) (string, *tls.Conn) {
+	conn, err := tls.Dial("tcp", f.addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping(t, conn)
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, conn
+}
+
+func ping(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("got %q, %v from the backend", line, err)
+	}
+}
+
+func TestProxyRotatesForNewConnections(t *testing.T) {
+	f := start(t, false)
+	defer f.close()
+
+	cn, established := f.connect(t)
+	defer established.Close()
+	if cn != "first" {
+		t.Fatalf("serving %s", cn)
+	}
+
+	writeCert(t, f.dir, "second")
+	if _, err := f.store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cn, conn := f.connect(t)
+	conn.Close()
+	if cn != "second" {
+		t.Fatalf("serving %s after the rotation", cn)
+	}
+	// Established connections, e.g. an attach, are not interrupted
+	ping(t, established)
+}
+
+func TestProxyDialsBackendWithTLS(t *testing.T) {
+	f := start(t, true)
+	defer f.close()
+
+	_, conn := f.connect(t)
+	conn.Close()
+
+	// A rotation to another CA leaves the hop to the backend alone
+	writeCert(t, f.dir, "second")
+	if _, err := f.store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cn, conn := f.connect(t)
+	conn.Close()
+	if cn != "second" {
+		t.Fatalf("serving %s after the rotation", cn)
+	}
+}
+
+func TestNewRejectsUnknownBackend(t *testing.T) {
+	if _, err := New("http://docker:2375", nil, nil); err == nil {
+		t.Fatal("http backend accepted")
+	}
+}
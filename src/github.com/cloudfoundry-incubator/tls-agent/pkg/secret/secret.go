@@ -0,0 +1,118 @@
+// Package secret mirrors a kubernetes.io/tls Secret of the Service Fabrik
+// apiserver to the certificate files watched by the agent.
+package secret
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/config"
+)
+
+// Syncer writes the Secret data to the certificate files.
+type Syncer struct {
+	secret                    config.Secret
+	certFile, keyFile, caFile string
+	client                    *http.Client
+}
+
+// NewSyncer returns the syncer of secret to the given files.
+func NewSyncer(secret config.Secret, certFile, keyFile, caFile string) (*Syncer, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if secret.CA != "" {
+		ca, err := ioutil.ReadFile(secret.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", secret.CA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &Syncer{
+		secret:   secret,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Sync fetches the Secret and rewrites the files whose content changed.
+func (s *Syncer) Sync() error {
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", s.secret.URL, s.secret.Namespace, s.secret.Name)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.secret.Token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return err
+	}
+
+	files := []struct{ key, path string }{
+		{"tls.crt", s.certFile},
+		{"tls.key", s.keyFile},
+		{"ca.crt", s.caFile},
+	}
+	for _, file := range files {
+		encoded, ok := secret.Data[file.key]
+		if !ok || file.path == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %v", file.key, err)
+		}
+		if err := writeIfChanged(file.path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIfChanged replaces path atomically, so the store never reads a
+// partially written file.
+func writeIfChanged(path string, data []byte) error {
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,79 @@
+package secret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/tls-agent/pkg/config"
+)
+
+func TestSync(t *testing.T) {
+	data := map[string]string{"tls.crt": "cert v1", "tls.key": "key v1", "ca.crt": "ca v1"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/sf/secrets/docker-tls" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-reader" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"tls.crt": %q, "tls.key": %q, "ca.crt": %q}}`,
+			base64.StdEncoding.EncodeToString([]byte(data["tls.crt"])),
+			base64.StdEncoding.EncodeToString([]byte(data["tls.key"])),
+			base64.StdEncoding.EncodeToString([]byte(data["ca.crt"])))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "tls-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert"), filepath.Join(dir, "key"), filepath.Join(dir, "ca")
+	secret := config.Secret{URL: server.URL, Token: "secret-reader", Namespace: "sf", Name: "docker-tls"}
+
+	s, err := NewSyncer(secret, certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	check := func(path, want string) {
+		got, err := ioutil.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+		}
+	}
+	check(certFile, "cert v1")
+	check(keyFile, "key v1")
+	check(caFile, "ca v1")
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, %v", info.Mode(), err)
+	}
+
+	data["tls.crt"], data["tls.key"] = "cert v2", "key v2"
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	check(certFile, "cert v2")
+	check(keyFile, "key v2")
+	check(caFile, "ca v1")
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.*")); len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+
+	secret.Token = "admin"
+	s, _ = NewSyncer(secret, certFile, keyFile, caFile)
+	if err := s.Sync(); err == nil {
+		t.Error("forbidden GET did not fail the sync")
+	}
+	check(certFile, "cert v2")
+}
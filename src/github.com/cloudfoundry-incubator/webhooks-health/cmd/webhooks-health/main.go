@@ -0,0 +1,65 @@
+// webhooks-health probes the manager-webhooks server the way the apiserver
+// calls it and serves the outcome on /healthz and /readyz. It runs next to
+// the webhooks server, which has no health endpoints of its own, and probes
+// it from the outside. Optionally it switches the failurePolicy of a
+// webhook configuration to Ignore when the webhooks turn not ready.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/checks"
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/config"
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/health"
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/policy"
+)
+
+func main() {
+	configPath := flag.String("config", "/var/vcap/jobs/webhooks/config/health.json", "path to the configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading configuration: %v", err)
+	}
+	prober, err := checks.NewProber(cfg.CA, cfg.ClientCert, cfg.ClientKey)
+	if err != nil {
+		log.Fatalf("loading certificates: %v", err)
+	}
+	tlsCheck, err := prober.TLS(cfg.Webhook)
+	if err != nil {
+		log.Fatalf("parsing webhook URL: %v", err)
+	}
+	monitor := health.NewMonitor(time.Duration(cfg.Timeout), cfg.FailureThreshold,
+		tlsCheck,
+		prober.APIServer(cfg.APIServer),
+		prober.Webhook(cfg.Webhook, cfg.ProbePath),
+	)
+	if g := cfg.FailurePolicyGuard; g != nil {
+		guard := policy.NewGuard(cfg.APIServer, g.Configuration, prober.Client())
+		monitor.OnCheck = guard.Apply
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{Addr: cfg.Listen, Handler: monitor.Handler()}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		server.Close()
+	}()
+	go monitor.Run(ctx, time.Duration(cfg.Interval))
+
+	log.Printf("serving /healthz and /readyz on %s", cfg.Listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("serving %s: %v", cfg.Listen, err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package checks probes the manager-webhooks server and the apiserver it
+// depends on from the outside, the way the apiserver reaches them.
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Check is a single named probe.
+type Check struct {
+	Name string
+	// Liveness checks also fail /healthz, the others only /readyz.
+	Liveness bool
+	Run      func(ctx context.Context) error
+}
+
+// Prober builds the checks sharing one TLS configuration.
+type Prober struct {
+	tls    *tls.Config
+	client *http.Client
+}
+
+// NewProber trusts caFile and authenticates with the client certificate.
+func NewProber(caFile, certFile, keyFile string) (*Prober, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Each probe opens a new connection, so a hung server is not hidden
+	// behind an idle keep-alive connection
+	transport.DisableKeepAlives = true
+	return &Prober{tls: tlsConfig, client: &http.Client{Transport: transport}}, nil
+}
+
+// Client returns the HTTP client authenticated against the apiserver.
+func (p *Prober) Client() *http.Client {
+	return p.client
+}
+
+// TLS checks that the webhook serves a certificate the apiserver trusts
+// and that is not expired.
+func (p *Prober) TLS(webhook string) (Check, error) {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return Check{}, err
+	}
+	return Check{
+		Name: "tls",
+		Run: func(ctx context.Context) error {
+			dialer := &net.Dialer{}
+			raw, err := dialer.DialContext(ctx, "tcp", u.Host)
+			if err != nil {
+				return err
+			}
+			defer raw.Close()
+			if deadline, ok := ctx.Deadline(); ok {
+				raw.SetDeadline(deadline)
+			}
+			cfg := p.tls.Clone()
+			cfg.ServerName = u.Hostname()
+			conn := tls.Client(raw, cfg)
+			if err := conn.Handshake(); err != nil {
+				return err
+			}
+			leaf := conn.ConnectionState().PeerCertificates[0]
+			if time.Now().After(leaf.NotAfter) {
+				return fmt.Errorf("certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Webhook sends an empty admission request to the webhook. The webhook
+// server rejects it in the AdmissionReview response without calling the
+// handler, so the probe has no side effects, yet it needs the complete
+// HTTP path to answer. controller-runtime only starts the webhook server
+// once the informer caches synced, so an answer also means synced caches.
+func (p *Prober) Webhook(webhook, path string) Check {
+	return Check{
+		Name:     "webhook",
+		Liveness: true,
+		Run: func(ctx context.Context) error {
+			req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(webhook, "/")+path, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := p.client.Do(req.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("POST %s returned %s", path, resp.Status)
+			}
+			var review struct {
+				Response *json.RawMessage `json:"response"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+				return fmt.Errorf("decoding admission review: %v", err)
+			}
+			if review.Response == nil {
+				return fmt.Errorf("admission review without response")
+			}
+			return nil
+		},
+	}
+}
+
+// APIServer checks that the apiserver is reachable and healthy.
+func (p *Prober) APIServer(apiserver string) Check {
+	return Check{
+		Name: "apiserver",
+		Run: func(ctx context.Context) error {
+			req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(apiserver, "/")+"/healthz", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := p.client.Do(req.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("GET /healthz returned %s", resp.Status)
+			}
+			return nil
+		},
+	}
+}
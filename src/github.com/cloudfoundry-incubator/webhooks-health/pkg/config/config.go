@@ -0,0 +1,98 @@
+// Package config loads the webhooks-health configuration rendered by the
+// webhooks BOSH job.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the webhooks-health configuration.
+type Config struct {
+	// Listen is the host:port serving /healthz and /readyz.
+	Listen string `json:"listen"`
+
+	// Webhook is the base URL the apiserver calls the webhooks on, e.g.
+	// https://10.0.0.10:9444.
+	Webhook string `json:"webhook"`
+	// ProbePath is the webhook path the admission round trip is sent to.
+	ProbePath string `json:"probe_path"`
+
+	// APIServer is the Service Fabrik apiserver URL.
+	APIServer string `json:"apiserver"`
+	// CA verifies both the apiserver and the webhook certificates.
+	CA string `json:"ca"`
+	// ClientCert and ClientKey authenticate against the apiserver.
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+
+	// Interval is the time between two rounds of checks and Timeout the
+	// time a single check may take.
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	// FailureThreshold is the number of failed rounds after which the
+	// webhooks are reported not ready.
+	FailureThreshold int `json:"failure_threshold"`
+
+	// FailurePolicyGuard, if set, switches the failurePolicy of a
+	// MutatingWebhookConfiguration to Ignore when the webhooks turn not
+	// ready, and back once they are.
+	FailurePolicyGuard *Guard `json:"failure_policy_guard,omitempty"`
+}
+
+// Guard names the MutatingWebhookConfiguration whose failurePolicy follows
+// the readiness of the webhooks.
+type Guard struct {
+	Configuration string `json:"configuration"`
+}
+
+// Duration is a time.Duration read from a string such as "10s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		ProbePath:        "/meter",
+		Interval:         Duration(10 * time.Second),
+		Timeout:          Duration(5 * time.Second),
+		FailureThreshold: 3,
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if cfg.Listen == "" || cfg.Webhook == "" || cfg.APIServer == "" {
+		return nil, fmt.Errorf("listen, webhook and apiserver are required")
+	}
+	if cfg.CA == "" || cfg.ClientCert == "" || cfg.ClientKey == "" {
+		return nil, fmt.Errorf("ca, client_cert and client_key are required")
+	}
+	if cfg.Interval <= 0 || cfg.Timeout <= 0 || cfg.FailureThreshold < 1 {
+		return nil, fmt.Errorf("interval, timeout and failure_threshold must be positive")
+	}
+	if g := cfg.FailurePolicyGuard; g != nil {
+		if g.Configuration == "" {
+			return nil, fmt.Errorf("failure_policy_guard requires configuration")
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,166 @@
+// Package health runs the checks periodically and serves their results on
+// /healthz and /readyz.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/checks"
+)
+
+// Result is the last outcome of a check.
+type Result struct {
+	Name     string    `json:"name"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"consecutiveFailures"`
+	Checked  time.Time `json:"lastChecked"`
+	liveness bool
+}
+
+// Monitor keeps the results of the checks. A check counts as failed once it
+// failed threshold times in a row, so a single slow probe does not flap the
+// readiness.
+type Monitor struct {
+	checks    []checks.Check
+	timeout   time.Duration
+	threshold int
+
+	mu      sync.RWMutex
+	results []Result
+	ready   bool
+	synced  bool
+
+	// OnCheck is called with the readiness after every round of checks.
+	OnCheck func(ready bool)
+}
+
+// NewMonitor returns the monitor of the given checks.
+func NewMonitor(timeout time.Duration, threshold int, cs ...checks.Check) *Monitor {
+	results := make([]Result, len(cs))
+	for i, c := range cs {
+		results[i] = Result{Name: c.Name, liveness: c.Liveness}
+	}
+	return &Monitor{checks: cs, timeout: timeout, threshold: threshold, results: results}
+}
+
+// Run checks every interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.CheckAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll runs all checks concurrently and updates the readiness.
+func (m *Monitor) CheckAll(ctx context.Context) {
+	errs := make([]error, len(m.checks))
+	var wg sync.WaitGroup
+	for i, c := range m.checks {
+		wg.Add(1)
+		go func(i int, c checks.Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			errs[i] = c.Run(checkCtx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	now := time.Now()
+	ready := true
+	for i, err := range errs {
+		r := &m.results[i]
+		r.Checked = now
+		if err == nil {
+			if r.Failures > 0 {
+				log.Printf("check %s recovered", r.Name)
+			}
+			r.OK, r.Error, r.Failures = true, "", 0
+			continue
+		}
+		r.Failures++
+		r.Error = err.Error()
+		log.Printf("check %s failed (%d in a row): %v", r.Name, r.Failures, err)
+		// Also at startup, e.g. while the webhooks server is still starting
+		r.OK = r.Failures < m.threshold
+		if !r.OK {
+			ready = false
+		}
+	}
+	changed := !m.synced || ready != m.ready
+	m.ready, m.synced = ready, true
+	m.mu.Unlock()
+
+	if changed {
+		log.Printf("webhooks ready: %t", ready)
+	}
+	if m.OnCheck != nil {
+		m.OnCheck(ready)
+	}
+}
+
+// Ready reports whether all checks pass.
+func (m *Monitor) Ready() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.synced && m.ready
+}
+
+// Live reports whether the liveness checks pass. Before the first round of
+// checks the webhooks count as live, so a starting server is not restarted.
+func (m *Monitor) Live() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.results {
+		if r.liveness && r.Failures >= m.threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler serves /healthz and /readyz. Both answer 200 or 503 with the
+// check results as JSON.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		m.write(w, m.Live(), true)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		m.write(w, m.Ready(), false)
+	})
+	return mux
+}
+
+func (m *Monitor) write(w http.ResponseWriter, ok, livenessOnly bool) {
+	m.mu.RLock()
+	var results []Result
+	for _, r := range m.results {
+		if !livenessOnly || r.liveness {
+			results = append(results, r)
+		}
+	}
+	m.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		OK     bool     `json:"ok"`
+		Checks []Result `json:"checks"`
+	}{ok, results})
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/webhooks-health/pkg/checks"
+)
+
+// switchable returns a check failing while *fail is set.
+func switchable(name string, liveness bool, fail *bool) checks.Check {
+	return checks.Check{Name: name, Liveness: liveness, Run: func(ctx context.Context) error {
+		if *fail {
+			return errors.New("down")
+		}
+		return nil
+	}}
+}
+
+func status(t *testing.T, m *Monitor, path string) int {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestMonitorBeforeFirstRound(t *testing.T) {
+	fail := false
+	m := NewMonitor(time.Second, 3, switchable("tls", true, &fail))
+	if m.Ready() {
+		t.Error("ready before the first round of checks")
+	}
+	if !m.Live() {
+		t.Error("not live before the first round of checks")
+	}
+	if code := status(t, m, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz: status %d", code)
+	}
+	if code := status(t, m, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz: status %d", code)
+	}
+}
+
+func TestMonitorFailsAfterThreshold(t *testing.T) {
+	liveFail, readyFail := false, false
+	m := NewMonitor(time.Second, 3,
+		switchable("tls", true, &liveFail),
+		switchable("webhook", false, &readyFail),
+	)
+	var rounds []bool
+	m.OnCheck = func(ready bool) { rounds = append(rounds, ready) }
+
+	m.CheckAll(context.Background())
+	if !m.Ready() || !m.Live() {
+		t.Fatal("passing checks not ready and live")
+	}
+
+	liveFail, readyFail = true, true
+	for i := 1; i < 3; i++ {
+		m.CheckAll(context.Background())
+		if !m.Ready() || !m.Live() {
+			t.Fatalf("failed after %d failures, below the threshold", i)
+		}
+	}
+	m.CheckAll(context.Background())
+	if m.Ready() {
+		t.Error("ready after reaching the threshold")
+	}
+	if m.Live() {
+		t.Error("live after reaching the threshold")
+	}
+	if code := status(t, m, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz: status %d", code)
+	}
+
+	// A single success recovers
+	liveFail, readyFail = false, false
+	m.CheckAll(context.Background())
+	if !m.Ready() || !m.Live() {
+		t.Error("not recovered after a passing round")
+	}
+
+	want := []bool{true, true, true, false, true}
+	if len(rounds) != len(want) {
+		t.Fatalf("OnCheck called with %v, want %v", rounds, want)
+	}
+	for i := range want {
+		if rounds[i] != want[i] {
+			t.Fatalf("OnCheck called with %v, want %v", rounds, want)
+		}
+	}
+}
+
+func TestMonitorThresholdAtStartup(t *testing.T) {
+	fail := true
+	m := NewMonitor(time.Second, 3, switchable("webhook", false, &fail))
+	var rounds []bool
+	m.OnCheck = func(ready bool) { rounds = append(rounds, ready) }
+
+	for i := 0; i < 3; i++ {
+		m.CheckAll(context.Background())
+	}
+	want := []bool{true, true, false}
+	for i := range want {
+		if len(rounds) != len(want) || rounds[i] != want[i] {
+			t.Fatalf("OnCheck called with %v from the start, want %v", rounds, want)
+		}
+	}
+}
+
+func TestMonitorReadinessOnlyCheck(t *testing.T) {
+	fail := true
+	m := NewMonitor(time.Second, 1, switchable("webhook", false, &fail))
+	m.CheckAll(context.Background())
+	if m.Ready() {
+		t.Error("ready with a failing check")
+	}
+	if !m.Live() {
+		t.Error("readiness check failed /healthz")
+	}
+}
+
+func TestMonitorTimesOutChecks(t *testing.T) {
+	m := NewMonitor(10*time.Millisecond, 1, checks.Check{Name: "hung", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	m.CheckAll(context.Background())
+	if m.Ready() {
+		t.Error("ready with a hung check")
+	}
+}
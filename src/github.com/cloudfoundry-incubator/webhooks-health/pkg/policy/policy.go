@@ -0,0 +1,215 @@
+// Package policy switches the failurePolicy of a webhook configuration with
+// the readiness of the webhooks, so a hung webhook server does not block
+// all writes to the resources it intercepts.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Ignore is the failurePolicy applied while the webhooks are not ready.
+const Ignore = "Ignore"
+
+// Annotation records on the configuration the webhooks the guard set to
+// Ignore, as JSON object of their names and the failurePolicy they had, so
+// that a restarted guard still restores them.
+const Annotation = "webhooks-health.servicefabrik.io/switched-failure-policies"
+
+// Guard patches the failurePolicy of the webhooks of a
+// MutatingWebhookConfiguration when the readiness changes. It only acts on
+// transitions and on its first call: while the readiness stays the same
+// the configuration is left to others, e.g. the post-start script
+// registering the webhooks again.
+type Guard struct {
+	url    string
+	client *http.Client
+
+	// applied is the readiness the configuration was last patched for,
+	// synced whether it was patched at all since the start
+	applied bool
+	synced  bool
+}
+
+// NewGuard returns the guard of the MutatingWebhookConfiguration name.
+func NewGuard(apiserver, name string, client *http.Client) *Guard {
+	return &Guard{
+		url:    fmt.Sprintf("%s/apis/admissionregistration.k8s.io/v1beta1/mutatingwebhookconfigurations/%s", strings.TrimSuffix(apiserver, "/"), name),
+		client: client,
+	}
+}
+
+// Apply switches the webhooks to Ignore when they turn not ready, and
+// restores the webhooks it switched once they are ready again, unless
+// someone changed their failurePolicy in between. The first call
+// reconciles the configuration with the readiness, e.g. restores the
+// webhooks left on Ignore before a restart. A failed patch is retried on
+// the next call.
+func (g *Guard) Apply(ready bool) {
+	if g.synced && ready == g.applied {
+		return
+	}
+	var err error
+	if ready {
+		err = g.restore()
+	} else {
+		err = g.ignore()
+	}
+	if err != nil {
+		log.Printf("updating the failurePolicy of %s for ready %t: %v", g.url, ready, err)
+		return
+	}
+	g.applied, g.synced = ready, true
+}
+
+type webhook struct {
+	Name          string `json:"name"`
+	FailurePolicy string `json:"failurePolicy"`
+}
+
+type configuration struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Webhooks []webhook `json:"webhooks"`
+}
+
+// switched returns the webhooks recorded in the annotation.
+func (c *configuration) switched() map[string]string {
+	switched := map[string]string{}
+	if value, ok := c.Metadata.Annotations[Annotation]; ok {
+		if err := json.Unmarshal([]byte(value), &switched); err != nil {
+			log.Printf("ignoring annotation %s: %v", Annotation, err)
+		}
+	}
+	return switched
+}
+
+type operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (g *Guard) ignore() error {
+	c, err := g.get()
+	if err != nil || c == nil {
+		return err
+	}
+	var ops []operation
+	// Webhooks switched before a restart keep the policy recorded for them
+	switched := c.switched()
+	for i, w := range c.Webhooks {
+		if w.FailurePolicy == Ignore {
+			continue
+		}
+		ops = append(ops, setPolicy(i, w.Name, Ignore)...)
+		switched[w.Name] = w.FailurePolicy
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	value, err := json.Marshal(switched)
+	if err != nil {
+		return err
+	}
+	if c.Metadata.Annotations == nil {
+		ops = append(ops, operation{"add", "/metadata/annotations", map[string]string{Annotation: string(value)}})
+	} else {
+		ops = append(ops, operation{"add", annotationPath, string(value)})
+	}
+	if err := g.patch(ops); err != nil {
+		return err
+	}
+	log.Printf("set failurePolicy %s on %d webhooks of %s", Ignore, len(ops)/2, g.url)
+	return nil
+}
+
+func (g *Guard) restore() error {
+	c, err := g.get()
+	if err != nil || c == nil {
+		return err
+	}
+	if _, ok := c.Metadata.Annotations[Annotation]; !ok {
+		return nil
+	}
+	var ops []operation
+	switched := c.switched()
+	for i, w := range c.Webhooks {
+		policy, ok := switched[w.Name]
+		if ok && w.FailurePolicy == Ignore {
+			ops = append(ops, setPolicy(i, w.Name, policy)...)
+		}
+	}
+	restored := len(ops) / 2
+	ops = append(ops, operation{Op: "remove", Path: annotationPath})
+	if err := g.patch(ops); err != nil {
+		return err
+	}
+	if restored > 0 {
+		log.Printf("restored the failurePolicy of %d webhooks of %s", restored, g.url)
+	}
+	return nil
+}
+
+// annotationPath is the JSON pointer to the annotation, "/" is escaped as
+// "~1".
+var annotationPath = "/metadata/annotations/" + strings.Replace(Annotation, "/", "~1", -1)
+
+// setPolicy replaces the failurePolicy of the webhook at index i, failing
+// the patch if the webhooks were reordered since they were read.
+func setPolicy(i int, name, policy string) []operation {
+	return []operation{
+		{"test", fmt.Sprintf("/webhooks/%d/name", i), name},
+		{"replace", fmt.Sprintf("/webhooks/%d/failurePolicy", i), policy},
+	}
+}
+
+// get returns the configuration, nil if it is not registered (e.g.
+// metering is disabled).
+func (g *Guard) get() (*configuration, error) {
+	resp, err := g.client.Get(g.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET returned %s", resp.Status)
+	}
+	c := &configuration{}
+	if err := json.NewDecoder(resp.Body).Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (g *Guard) patch(ops []operation) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPatch, g.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PATCH returned %s", resp.Status)
+	}
+	return nil
+}
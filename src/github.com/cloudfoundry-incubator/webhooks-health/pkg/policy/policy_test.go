@@ -0,0 +1,242 @@
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPIServer serves one MutatingWebhookConfiguration and applies the
+// JSON patches sent to it.
+type fakeAPIServer struct {
+	mu          sync.Mutex
+	webhooks    []webhook
+	annotations map[string]string
+	missing     bool
+	fail        bool
+	patches     int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.missing {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": f.annotations},
+			"webhooks": f.webhooks,
+		})
+	case http.MethodPatch:
+		f.patches++
+		if f.fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var (
+			ops []operation
+			ok  bool
+		)
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		webhooks := append([]webhook(nil), f.webhooks...)
+		annotations := f.annotations
+		for _, op := range ops {
+			if strings.HasPrefix(op.Path, "/metadata/annotations") {
+				if annotations, ok = patchAnnotations(annotations, op); !ok {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					return
+				}
+				continue
+			}
+			parts := strings.Split(op.Path, "/")
+			i, _ := strconv.Atoi(parts[2])
+			switch {
+			case op.Op == "test" && webhooks[i].Name != op.Value:
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			case op.Op == "replace":
+				webhooks[i].FailurePolicy = op.Value.(string)
+			}
+		}
+		f.webhooks, f.annotations = webhooks, annotations
+	}
+}
+
+// patchAnnotations applies op to a copy of annotations, failing like the
+// apiserver for a missing parent or member.
+func patchAnnotations(annotations map[string]string, op operation) (map[string]string, bool) {
+	copied := map[string]string{}
+	for k, v := range annotations {
+		copied[k] = v
+	}
+	if op.Path == "/metadata/annotations" {
+		if op.Op != "add" {
+			return nil, false
+		}
+		for k, v := range op.Value.(map[string]interface{}) {
+			copied[k] = v.(string)
+		}
+		return copied, true
+	}
+	if annotations == nil {
+		return nil, false
+	}
+	key := strings.Replace(strings.TrimPrefix(op.Path, "/metadata/annotations/"), "~1", "/", -1)
+	switch op.Op {
+	case "add":
+		copied[key] = op.Value.(string)
+	case "remove":
+		if _, ok := copied[key]; !ok {
+			return nil, false
+		}
+		delete(copied, key)
+	default:
+		return nil, false
+	}
+	return copied, true
+}
+
+func (f *fakeAPIServer) policies() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var policies []string
+	for _, w := range f.webhooks {
+		policies = append(policies, w.FailurePolicy)
+	}
+	return policies
+}
+
+func newGuard(t *testing.T, f *fakeAPIServer) (*Guard, func()) {
+	server := httptest.NewServer(f)
+	return NewGuard(server.URL, "metering-webhooks", server.Client()), server.Close
+}
+
+func expect(t *testing.T, f *fakeAPIServer, want ...string) {
+	t.Helper()
+	got := f.policies()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("failurePolicy %v, want %v", got, want)
+	}
+}
+
+func TestGuardActsOnTransitionsOnly(t *testing.T) {
+	f := &fakeAPIServer{webhooks: []webhook{{"meter", "Fail"}, {"audit", Ignore}}}
+	g, done := newGuard(t, f)
+	defer done()
+
+	g.Apply(true)
+	if f.patches != 0 {
+		t.Fatalf("%d patches while ready from the start", f.patches)
+	}
+
+	g.Apply(false)
+	expect(t, f, Ignore, Ignore)
+	// post-start registers the webhooks again while still not ready
+	f.webhooks[0].FailurePolicy = "Fail"
+	g.Apply(false)
+	expect(t, f, "Fail", Ignore)
+	if f.patches != 1 {
+		t.Fatalf("%d patches, want one per transition", f.patches)
+	}
+}
+
+func TestGuardRestoresOnlySwitchedWebhooks(t *testing.T) {
+	f := &fakeAPIServer{webhooks: []webhook{{"meter", "Fail"}, {"audit", Ignore}, {"quota", "Fail"}}}
+	g, done := newGuard(t, f)
+	defer done()
+
+	g.Apply(false)
+	expect(t, f, Ignore, Ignore, Ignore)
+	// Changed in between by someone else
+	f.webhooks[2].FailurePolicy = "Fail"
+
+	g.Apply(true)
+	// audit was Ignore before the guard switched anything
+	expect(t, f, "Fail", Ignore, "Fail")
+	if _, ok := f.annotations[Annotation]; ok {
+		t.Errorf("annotation left after restoring: %v", f.annotations)
+	}
+}
+
+func TestGuardRestoresAfterRestart(t *testing.T) {
+	f := &fakeAPIServer{
+		webhooks:    []webhook{{"meter", "Fail"}, {"audit", Ignore}},
+		annotations: map[string]string{"owner": "broker"},
+	}
+	g, done := newGuard(t, f)
+	defer done()
+	g.Apply(false)
+	expect(t, f, Ignore, Ignore)
+	if f.annotations["owner"] != "broker" || f.annotations[Annotation] != `{"meter":"Fail"}` {
+		t.Fatalf("annotations after switching: %v", f.annotations)
+	}
+
+	// A new guard starts from the configuration left on Ignore
+	g, done = newGuard(t, f)
+	defer done()
+	g.Apply(true)
+	expect(t, f, "Fail", Ignore)
+	if _, ok := f.annotations[Annotation]; ok || f.annotations["owner"] != "broker" {
+		t.Errorf("annotations after restoring: %v", f.annotations)
+	}
+
+	// Still not ready after a restart: the recorded policy is kept
+	g.Apply(false)
+	g, done = newGuard(t, f)
+	defer done()
+	g.Apply(false)
+	if f.annotations[Annotation] != `{"meter":"Fail"}` {
+		t.Fatalf("annotation after switching again: %v", f.annotations)
+	}
+	g.Apply(true)
+	expect(t, f, "Fail", Ignore)
+}
+
+func TestGuardRetriesFailedPatch(t *testing.T) {
+	f := &fakeAPIServer{webhooks: []webhook{{"meter", "Fail"}}, fail: true}
+	g, done := newGuard(t, f)
+	defer done()
+
+	g.Apply(false)
+	expect(t, f, "Fail")
+	f.fail = false
+	g.Apply(false)
+	expect(t, f, Ignore)
+}
+
+func TestGuardReorderedWebhooks(t *testing.T) {
+	f := &fakeAPIServer{webhooks: []webhook{{"meter", "Fail"}}}
+	g, done := newGuard(t, f)
+	defer done()
+
+	g.Apply(false)
+	// Registered again with another webhook in front
+	f.webhooks = []webhook{{"audit", "Fail"}, {"meter", Ignore}}
+	g.Apply(true)
+	expect(t, f, "Fail", "Fail")
+}
+
+func TestGuardMissingConfiguration(t *testing.T) {
+	f := &fakeAPIServer{missing: true}
+	g, done := newGuard(t, f)
+	defer done()
+
+	g.Apply(false)
+	g.Apply(true)
+	if f.patches != 0 {
+		t.Fatalf("%d patches of a missing configuration", f.patches)
+	}
+	if !g.applied {
+		t.Error("transition to ready not recorded")
+	}
+}